@@ -0,0 +1,128 @@
+package cowtransfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	listTransfersURL     = "%s/api/transfer/userhistory?page=%d&size=%d"
+	listTransfersPerPage = 50
+)
+
+// TransferSummary is an uploaded transfer listed by ListTransfers.
+type TransferSummary struct {
+	GUID          string    `json:"guid"`
+	UniqueURL     string    `json:"unique_url"`
+	Name          string    `json:"name"`
+	Size          int64     `json:"size"`
+	FileCount     int       `json:"file_count"`
+	CreatedAt     time.Time `json:"created_at"`
+	// ExpiresAt is zero if the transfer never expires.
+	ExpiresAt     time.Time `json:"expires_at"`
+	DownloadCount int       `json:"download_count"`
+}
+
+// TransferPage is a page of transfers uploaded by the logged in account.
+type TransferPage struct {
+	Transfers []TransferSummary `json:"transfers"`
+	// Page is the page number (0-based).
+	Page int `json:"page"`
+	// Pages is the total number of pages.
+	Pages int `json:"pages"`
+	// QuotaUsed is the storage used by the account in bytes.
+	QuotaUsed int64 `json:"quota_used"`
+	// QuotaTotal is the storage available to the account in bytes.
+	QuotaTotal int64 `json:"quota_total"`
+}
+
+// listTransfersResponse is expected response from listTransfersURL API.
+type listTransfersResponse struct {
+	Data         []listTransfersItem `json:"data"`
+	Pages        int                 `json:"totalPages"`
+	UsedSpace    int64               `json:"usedSpace"`
+	TotalSpace   int64               `json:"totalSpace"`
+	Error        bool                `json:"error"`
+	ErrorMessage string              `json:"error_message"`
+}
+
+type listTransfersItem struct {
+	GUID          string `json:"guid"`
+	UniqueURL     string `json:"uniqueUrl"`
+	Name          string `json:"transferName"`
+	Size          int64  `json:"totalSize"`
+	FileCount     int    `json:"fileCount"`
+	CreatedAt     int64  `json:"createdAt"`
+	ExpireAt      int64  `json:"expireAt"`
+	DownloadCount int    `json:"downloadTimes"`
+}
+
+// ListTransfersPage returns a page (0-based) of transfers uploaded by the 
+// logged in account. The account session is taken from Cookies or Token. 
+// Returns ErrNotLoggedIn without a valid session.
+func (cc *CowClient) ListTransfersPage(page int) (*TransferPage, error) {
+	if page < 0 {
+		return nil, fmt.Errorf("page cannot be negative: %d", page)
+	}
+
+	responseBytes, err := cc.newFileDownloadRequest(fmt.Sprintf(listTransfersURL, cc.APIURL, page, listTransfersPerPage), "")
+	if err != nil {
+		return nil, err
+	}
+
+	response := new(listTransfersResponse)
+	if err := json.Unmarshal(responseBytes, response); err != nil {
+		return nil, err
+	}
+	if response.Error {
+		if response.ErrorMessage == "" {
+			return nil, ErrNotLoggedIn
+		}
+		return nil, fmt.Errorf("%w: %s", ErrNotLoggedIn, response.ErrorMessage)
+	}
+
+	result := &TransferPage{
+		Transfers:  []TransferSummary{},
+		Page:       page,
+		Pages:      response.Pages,
+		QuotaUsed:  response.UsedSpace,
+		QuotaTotal: response.TotalSpace,
+	}
+	for _, v := range response.Data {
+		summary := TransferSummary{
+			GUID:          v.GUID,
+			UniqueURL:     v.UniqueURL,
+			Name:          v.Name,
+			Size:          v.Size,
+			FileCount:     v.FileCount,
+			CreatedAt:     time.Unix(0, v.CreatedAt*int64(time.Millisecond)),
+			DownloadCount: v.DownloadCount,
+		}
+		if v.ExpireAt > 0 {
+			summary.ExpiresAt = time.Unix(0, v.ExpireAt*int64(time.Millisecond))
+		}
+		result.Transfers = append(result.Transfers, summary)
+	}
+	return result, nil
+}
+
+// ListTransfers returns all transfers uploaded by the logged in account. The 
+// quota fields of the result are taken from the last page.
+func (cc *CowClient) ListTransfers() (*TransferPage, error) {
+	result, err := cc.ListTransfersPage(0)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < result.Pages; i++ {
+		more, err := cc.ListTransfersPage(i)
+		if err != nil {
+			return nil, err
+		}
+		result.Transfers = append(result.Transfers, more.Transfers...)
+		result.QuotaUsed = more.QuotaUsed
+		result.QuotaTotal = more.QuotaTotal
+	}
+	return result, nil
+}
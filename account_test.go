@@ -0,0 +1,46 @@
+package cowtransfer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListTransfers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			fmt.Fprint(w, `{"error":true}`)
+			return
+		}
+		page := r.URL.Query().Get("page")
+		fmt.Fprintf(w, `{"data":[{"guid":"g%s","uniqueUrl":"https://cowtransfer.com/s/abab000012345%s","totalSize":10,"createdAt":1600000000000,"expireAt":0}],"totalPages":2,"usedSpace":20,"totalSpace":100}`, page, page)
+	}))
+	defer ts.Close()
+
+	cc := NewClient()
+	cc.APIURL = ts.URL
+
+	if _, err := cc.ListTransfers(); !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("expected ErrNotLoggedIn, got %v", err)
+	}
+
+	cc.Token = "session=abc"
+	list, err := cc.ListTransfers()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Transfers) != 2 || list.Transfers[0].GUID != "g0" || list.Transfers[1].GUID != "g1" {
+		t.Errorf("unexpected transfers: %+v", list.Transfers)
+	}
+	if list.QuotaUsed != 20 || list.QuotaTotal != 100 {
+		t.Errorf("unexpected quota: %d/%d", list.QuotaUsed, list.QuotaTotal)
+	}
+	if got := list.Transfers[0].CreatedAt.Unix(); got != 1600000000 {
+		t.Errorf("unexpected creation time: %d", got)
+	}
+	if !list.Transfers[0].ExpiresAt.IsZero() {
+		t.Errorf("expected no expiry, got %v", list.Transfers[0].ExpiresAt)
+	}
+}
@@ -0,0 +1,53 @@
+package cowtransfer
+
+import (
+	"sync"
+)
+
+// blockPool hands out fixed size block buffers. Buffers are recycled with a
+// sync.Pool, and the number of buffers checked out at any one time is capped
+// so that an upload never holds more than its memory budget.
+type blockPool struct {
+	size int
+	pool sync.Pool
+	sem  chan struct{}
+}
+
+// newBlockPool creates a pool of blockSize buffers. At most maxMemory bytes
+// worth of buffers can be checked out at the same time, but there is always
+// room for at least 1 buffer.
+func newBlockPool(blockSize int, maxMemory int64) *blockPool {
+	slots := 1
+	if maxMemory > int64(blockSize) {
+		slots = int(maxMemory / int64(blockSize))
+	}
+
+	bp := &blockPool{
+		size: blockSize,
+		sem:  make(chan struct{}, slots),
+	}
+	bp.pool.New = func() interface{} {
+		buf := make([]byte, bp.size)
+		return &buf
+	}
+	return bp
+}
+
+// Slots returns the maximum number of buffers that can be checked out.
+func (bp *blockPool) Slots() int {
+	return cap(bp.sem)
+}
+
+// Get returns a buffer from the pool. It blocks until the memory budget
+// allows another buffer to be checked out.
+func (bp *blockPool) Get() []byte {
+	bp.sem <- struct{}{}
+	return *(bp.pool.Get().(*[]byte))
+}
+
+// Put returns a buffer obtained from Get back to the pool.
+func (bp *blockPool) Put(buf []byte) {
+	buf = buf[:cap(buf)]
+	bp.pool.Put(&buf)
+	<-bp.sem
+}
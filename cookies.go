@@ -0,0 +1,318 @@
+package cowtransfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const netscapeHttpOnlyPrefix = "#HttpOnly_"
+
+// Cookie is a cookie as stored on disk.
+type Cookie struct {
+	Domain string `json:"domain"`
+	// HostOnly is true if the cookie is not sent to subdomains of Domain.
+	HostOnly bool      `json:"host_only"`
+	Path     string    `json:"path"`
+	Secure   bool      `json:"secure"`
+	HttpOnly bool      `json:"http_only"`
+	// Expires is zero for session cookies.
+	Expires time.Time `json:"expires"`
+	Name    string    `json:"name"`
+	Value   string    `json:"value"`
+}
+
+// CookieJar is a http.CookieJar that can be saved to and loaded from disk,
+// either as JSON or in the Netscape cookies.txt format used by browser
+// extensions and curl. It is safe for concurrent use.
+type CookieJar struct {
+	jar     *cookiejar.Jar
+	mutex   sync.Mutex
+	entries map[string]*Cookie
+	// token is the last CowClient.Token added, with its domain
+	token string
+}
+
+// NewCookieJar creates an empty cookie jar.
+func NewCookieJar() *CookieJar {
+	// cookiejar.New only fails on invalid options
+	jar, _ := cookiejar.New(nil)
+	return &CookieJar{
+		jar:     jar,
+		entries: map[string]*Cookie{},
+	}
+}
+
+// SetCookies implements http.CookieJar.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+	for _, v := range cookies {
+		c := &Cookie{
+			Domain:   strings.TrimPrefix(strings.ToLower(v.Domain), "."),
+			Path:     v.Path,
+			Secure:   v.Secure,
+			HttpOnly: v.HttpOnly,
+			Expires:  v.Expires,
+			Name:     v.Name,
+			Value:    v.Value,
+		}
+		if c.Domain == "" {
+			c.Domain = strings.ToLower(u.Hostname())
+			c.HostOnly = true
+		}
+		if c.Path == "" || !strings.HasPrefix(c.Path, "/") {
+			c.Path = defaultCookiePath(u.Path)
+		}
+		if v.MaxAge > 0 {
+			c.Expires = now.Add(time.Duration(v.MaxAge) * time.Second)
+		}
+
+		key := c.Domain + ";" + c.Path + ";" + c.Name
+		if v.MaxAge < 0 || (!c.Expires.IsZero() && !c.Expires.After(now)) {
+			delete(j.entries, key)
+			continue
+		}
+		j.entries[key] = c
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// All returns every unexpired cookie in the jar, sorted by domain, path and
+// name.
+func (j *CookieJar) All() []Cookie {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+	result := []Cookie{}
+	for _, v := range j.entries {
+		if !v.Expires.IsZero() && !v.Expires.After(now) {
+			continue
+		}
+		result = append(result, *v)
+	}
+	sort.Slice(result, func(a, b int) bool {
+		if result[a].Domain != result[b].Domain {
+			return result[a].Domain < result[b].Domain
+		}
+		if result[a].Path != result[b].Path {
+			return result[a].Path < result[b].Path
+		}
+		return result[a].Name < result[b].Name
+	})
+	return result
+}
+
+// Add puts cookies into the jar.
+func (j *CookieJar) Add(cookies ...Cookie) {
+	for _, v := range cookies {
+		scheme := "http"
+		if v.Secure {
+			scheme = "https"
+		}
+		u := &url.URL{Scheme: scheme, Host: v.Domain, Path: v.Path}
+
+		hc := &http.Cookie{
+			Name:     v.Name,
+			Value:    v.Value,
+			Path:     v.Path,
+			Secure:   v.Secure,
+			HttpOnly: v.HttpOnly,
+			Expires:  v.Expires,
+		}
+		if !v.HostOnly {
+			hc.Domain = v.Domain
+		}
+		j.SetCookies(u, []*http.Cookie{hc})
+	}
+}
+
+// AddHeader parses a raw Cookie request header (like "a=1; b=2") and puts
+// the cookies into the jar as session cookies for domain and its subdomains.
+func (j *CookieJar) AddHeader(domain string, header string) {
+	req := http.Request{Header: http.Header{"Cookie": {header}}}
+	for _, v := range req.Cookies() {
+		j.Add(Cookie{
+			Domain: domain,
+			Path:   "/",
+			Name:   v.Name,
+			Value:  v.Value,
+		})
+	}
+}
+
+// addToken puts the cookies of a CowClient.Token into the jar for the host of 
+// apiURL, unless they were the last ones added.
+func (j *CookieJar) addToken(apiURL string, token string) {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Hostname() == "" {
+		return
+	}
+	key := u.Hostname() + ";" + token
+
+	j.mutex.Lock()
+	added := j.token == key
+	j.token = key
+	j.mutex.Unlock()
+	if !added {
+		j.AddHeader(u.Hostname(), token)
+	}
+}
+
+// WriteJSON writes all cookies in the jar to w as JSON.
+func (j *CookieJar) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(j.All())
+}
+
+// ReadJSON reads cookies written by WriteJSON into the jar.
+func (j *CookieJar) ReadJSON(r io.Reader) error {
+	cookies := []Cookie{}
+	if err := json.NewDecoder(r).Decode(&cookies); err != nil {
+		return fmt.Errorf("cannot parse cookies: %w", err)
+	}
+	j.Add(cookies...)
+	return nil
+}
+
+// WriteNetscape writes all cookies in the jar to w in the Netscape
+// cookies.txt format.
+func (j *CookieJar) WriteNetscape(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Netscape HTTP Cookie File\n\n")
+	for _, v := range j.All() {
+		domain := v.Domain
+		if !v.HostOnly {
+			domain = "." + domain
+		}
+		if v.HttpOnly {
+			domain = netscapeHttpOnlyPrefix + domain
+		}
+		expires := int64(0)
+		if !v.Expires.IsZero() {
+			expires = v.Expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(!v.HostOnly), v.Path, netscapeBool(v.Secure), expires, v.Name, v.Value)
+	}
+	return bw.Flush()
+}
+
+// ReadNetscape reads cookies in the Netscape cookies.txt format into the jar.
+func (j *CookieJar) ReadNetscape(r io.Reader) error {
+	cookies := []Cookie{}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := false
+		if strings.HasPrefix(line, netscapeHttpOnlyPrefix) {
+			httpOnly = true
+			line = strings.TrimPrefix(line, netscapeHttpOnlyPrefix)
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("cannot parse cookies line %d: expect 7 fields, got %d", lineNum, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot parse cookies line %d: %w", lineNum, err)
+		}
+
+		c := Cookie{
+			Domain:   strings.TrimPrefix(strings.ToLower(fields[0]), "."),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+			Name:     fields[5],
+			Value:    fields[6],
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	j.Add(cookies...)
+	return nil
+}
+
+// Save writes all cookies in the jar to a file. The Netscape cookies.txt
+// format is used if the file name ends with ".txt", otherwise JSON.
+func (j *CookieJar) Save(path string) error {
+	buffer := &bytes.Buffer{}
+	var err error
+	if strings.HasSuffix(strings.ToLower(path), ".txt") {
+		err = j.WriteNetscape(buffer)
+	} else {
+		err = j.WriteJSON(buffer)
+	}
+	if err != nil {
+		return err
+	}
+
+	// cookies are credentials, so keep them private
+	return os.WriteFile(path, buffer.Bytes(), 0600)
+}
+
+// Load reads cookies from a file written by Save, or exported from a
+// browser in the Netscape cookies.txt format.
+func (j *CookieJar) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return j.ReadJSON(bytes.NewReader(data))
+	}
+	return j.ReadNetscape(bytes.NewReader(data))
+}
+
+// defaultCookiePath returns the default cookie path for a request path. See 
+// RFC 6265 section 5.1.4.
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 || !strings.HasPrefix(path, "/") {
+		return "/"
+	}
+	return path[:i]
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
@@ -0,0 +1,119 @@
+package cowtransfer
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieJarNetscape(t *testing.T) {
+	input := "# Netscape HTTP Cookie File\n" +
+		".cowtransfer.com\tTRUE\t/\tTRUE\t4102444800\tremember\tabc\n" +
+		"#HttpOnly_cowtransfer.com\tFALSE\t/\tFALSE\t0\tsession\txyz\n" +
+		"\n"
+
+	jar := NewCookieJar()
+	if err := jar.ReadNetscape(strings.NewReader(input)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	u, _ := url.Parse("https://www.cowtransfer.com/")
+	if got := jar.Cookies(u); len(got) != 1 || got[0].Name != "remember" {
+		t.Errorf("expected only domain cookie on subdomain, got %v", got)
+	}
+	u, _ = url.Parse("https://cowtransfer.com/")
+	if got := jar.Cookies(u); len(got) != 2 {
+		t.Errorf("expected 2 cookies, got %v", got)
+	}
+
+	out := &bytes.Buffer{}
+	if err := jar.WriteNetscape(out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again := NewCookieJar()
+	if err := again.ReadNetscape(out); err != nil {
+		t.Fatalf("cannot read back: %v", err)
+	}
+	a, b := jar.All(), again.All()
+	if len(a) != 2 || len(a) != len(b) {
+		t.Fatalf("round trip mismatch: %v vs %v", a, b)
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].HostOnly != b[i].HostOnly || a[i].HttpOnly != b[i].HttpOnly || !a[i].Expires.Equal(b[i].Expires) {
+			t.Errorf("round trip mismatch: %+v vs %+v", a[i], b[i])
+		}
+	}
+}
+
+func TestCookieJarJSON(t *testing.T) {
+	jar := NewCookieJar()
+	u, _ := url.Parse("https://cowtransfer.com/transfer")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "a", Value: "1", MaxAge: 3600},
+		{Name: "b", Value: "2", Expires: time.Now().Add(-time.Hour)},
+	})
+
+	out := &bytes.Buffer{}
+	if err := jar.WriteJSON(out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again := NewCookieJar()
+	if err := again.ReadJSON(out); err != nil {
+		t.Fatalf("cannot read back: %v", err)
+	}
+
+	all := again.All()
+	if len(all) != 1 || all[0].Name != "a" || !all[0].HostOnly || all[0].Path != "/" {
+		t.Errorf("unexpected cookies: %+v", all)
+	}
+}
+
+func TestCookieJarAddHeader(t *testing.T) {
+	jar := NewCookieJar()
+	jar.AddHeader("cowtransfer.com", "a=1; b=2")
+
+	u, _ := url.Parse("https://www.cowtransfer.com/")
+	if got := jar.Cookies(u); len(got) != 2 || got[0].Value != "1" || got[1].Value != "2" {
+		t.Errorf("expected 2 cookies on subdomain, got %v", got)
+	}
+	if all := jar.All(); len(all) != 2 || !all[0].Expires.IsZero() || all[0].Domain != "cowtransfer.com" {
+		t.Errorf("expected session cookies for cowtransfer.com, got %+v", all)
+	}
+}
+
+func TestTokenCookies(t *testing.T) {
+	headers := []string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Values("Cookie")
+		fmt.Fprint(w, "true")
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	cc := NewClient()
+	cc.APIURL = ts.URL
+	cc.Token = "login=token; other=1"
+	cc.Cookies.Add(Cookie{Domain: u.Hostname(), HostOnly: true, Path: "/", Name: "login", Value: "old"})
+	if _, err := cc.newMultipartFormRequest(ts.URL+"/api", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Token replaces the jar cookie with the same name, and is sent once
+	if len(headers) != 1 || strings.Count(headers[0], "login=") != 1 || !strings.Contains(headers[0], "login=token") ||
+		!strings.Contains(headers[0], "other=1") || !strings.Contains(headers[0], "cf-cs-k-20181214=") {
+		t.Errorf("unexpected cookies: %v", headers)
+	}
+
+	// without a jar, Token goes in the header
+	cc.Cookies = nil
+	if _, err := cc.newMultipartFormRequest(ts.URL+"/api", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(headers) != 1 || !strings.HasPrefix(headers[0], "login=token; other=1;cf-cs-k-20181214=") {
+		t.Errorf("unexpected cookies: %v", headers)
+	}
+}
@@ -2,12 +2,14 @@ package cowtransfer
 
 import (
 	"encoding/json"
+	"net/http"
 	"time"
 )
 
 const (
 	defaultUA          = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/85.0.4183.102 Safari/537.36 Edg/85.0.564.51"
 	defaultBlockSize   = 4194304 // 4096kb
+	defaultMaxMemory   = 33554432 // 32mb
 )
 
 // UploadSession is a file upload session. Multiple files can be uploaded in a 
@@ -20,6 +22,9 @@ type UploadSession struct {
 	Prefix       string `json:"prefix"`
 	QRCode       string `json:"qrcode"`
 	TempCode     string `json:"temp_download_code"`
+	// Manifest has the hashes of all uploaded files if RecordHashes is set. 
+	// It is only set when the session is closed.
+	Manifest *Manifest `json:"manifest,omitempty"`
 }
 
 // File represents a file transfer operation.
@@ -71,6 +76,8 @@ const (
 	DoBlock
 	DoneBlock
 	RetryBlock
+	FailBlock
+	SkipTransfer
 )
 
 func (ts TransferState) String() string {
@@ -93,6 +100,10 @@ func (ts TransferState) String() string {
 		return "DoneBlock"
 	case RetryBlock:
 		return "RetryBlock"
+	case FailBlock:
+		return "FailBlock"
+	case SkipTransfer:
+		return "SkipTransfer"
 	default:
 		return "undefined"
 	}
@@ -102,7 +113,10 @@ func (ts TransferState) String() string {
 type FileTransferFunc func(ft *FileTransfer)
 // SessionOpenCloseFunc is a session creation and close event hook.
 type SessionOpenCloseFunc func(s *UploadSession)
-// PushBlockErrorHandler is a handler for block upload failure.
+// PushBlockErrorHandler is a handler for block upload failure. It is called 
+// once all retries of a block have failed. Return nil, ErrRetryBlock or 
+// RetryBlockAfter to try the block again, ErrSkipFile to skip the file, or 
+// ErrAbortSession (or any other error) to abort the upload.
 type PushBlockErrorHandler func(ft *FileTransfer) error
 
 // CowClient is a client for CowTransfer.cn
@@ -116,25 +130,60 @@ type CowClient struct {
 	UserAgent string
 	// VerifyHash will use MD5 checksum to verify each block.
 	VerifyHash bool
+	// RecordHashes computes the SHA-256 of every block and every file during 
+	// upload. When MaxPushBlocks is more than 1, files are read once more for 
+	// their hash. They are reported in UploadSession.Manifest when the 
+	// session closes.
+	RecordHashes bool
+	// NameFunc names files in the transfer. Defaults to BaseName, so files 
+	// with the same name in different directories collide.
+	NameFunc NameFunc
+	// CollisionPolicy decides what happens when NameFunc gives two files the 
+	// same name. Defaults to CollisionRename.
+	CollisionPolicy CollisionPolicy
 	// Password is an optional password that is used to protect content from 
 	// downloads.
 	Password string
-	// Token overrides the default cookie.
+	// Token is a raw cookie header (like "a=1; b=2") that is sent with every 
+	// request to Cowtransfer. Its cookies are put into Cookies, if set, where 
+	// they replace cookies with the same name.
 	Token string
+	// Cookies stores cookies set by Cowtransfer across requests. Load it 
+	// from disk to reuse a logged in session.
+	Cookies *CookieJar
 	// BlockSize is the size of each file part to download or upload. Defaults 
-	// to 4096kb.
+	// to 4096kb. Uploads require a value between 1mb and 1gb, and no more 
+	// than 10000 blocks per file.
 	BlockSize int
 	// MaxPushBlocks is the maximum number of file parts to upload 
 	// concurrently. This will be averaged amongst MaxPushFiles.
 	MaxPushBlocks int
+	// MaxMemory is the maximum number of bytes used by block buffers during 
+	// an upload. Block uploads wait for a free buffer once this budget is 
+	// reached. At least 1 block is always allowed. Defaults to 32mb.
+	MaxMemory int64
 	// APIURL overrides the default Cowtransfer API endpoint.
 	APIURL string
 	// OSSURL overrides the default Qiniu OSS API endpoint.
 	OSSURL string
-	// progress hooks
-	transferProgressHook FileTransferFunc
-	openSessionHook SessionOpenCloseFunc
-	closeSessionHook SessionOpenCloseFunc
+	// Logger receives log messages. Nothing is logged if not set.
+	Logger Logger
+	// Debug traces every API request to Logger at debug level, with method, 
+	// URL, status, timing and a truncated response body. Upload tokens, 
+	// cookies and passwords are redacted.
+	Debug bool
+	// Transport sends HTTP requests, like a HARRecorder or HARReplayer. 
+	// Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// EventDelivery selects how events and progress hooks are delivered. 
+	// Defaults to DirectDelivery.
+	EventDelivery EventDelivery
+	// progress events and the subscriptions held by On* hooks
+	events eventBus
+	transferProgressHook func()
+	openSessionHook func()
+	closeSessionHook func()
+	pushBlockErrorHandler PushBlockErrorHandler
 }
 
 // NewClient creates a new CowClient instance with default values.
@@ -143,8 +192,10 @@ func NewClient() *CowClient {
 		UserAgent: defaultUA,
 		BlockSize: defaultBlockSize,
 		MaxPushBlocks: 1,
+		MaxMemory: defaultMaxMemory,
 		Timeout: 10*time.Second,
 		Token: "",
+		Cookies: NewCookieJar(),
 		Password: "",
 		VerifyHash: true,
 		MaxRetry: 3,
@@ -153,17 +204,57 @@ func NewClient() *CowClient {
 	}
 }
 
-// OnStart is a progress hook for session start.
+// OnStart is a progress hook for session start. It replaces the previous 
+// OnStart hook, but not handlers added with Subscribe.
 func (cc *CowClient) OnStart(hook SessionOpenCloseFunc) {
-	cc.openSessionHook = hook
+	var handler EventHandler
+	if hook != nil {
+		handler = func(ev Event) {
+			if se, ok := ev.(SessionEvent); ok && !se.Closed {
+				hook(&se.UploadSession)
+			}
+		}
+	}
+	cc.setHook(&cc.openSessionHook, handler)
 }
 
-// OnStart is a progress hook for session stop.
+// OnStop is a progress hook for session stop. It replaces the previous 
+// OnStop hook, but not handlers added with Subscribe.
 func (cc *CowClient) OnStop(hook SessionOpenCloseFunc) {
-	cc.closeSessionHook = hook
+	var handler EventHandler
+	if hook != nil {
+		handler = func(ev Event) {
+			if se, ok := ev.(SessionEvent); ok && se.Closed {
+				hook(&se.UploadSession)
+			}
+		}
+	}
+	cc.setHook(&cc.closeSessionHook, handler)
 }
 
-// OnFileTransfer is a progress hook for file transfer progress.
+// OnFileTransfer is a progress hook for file transfer progress. It receives 
+// every FileEvent, BlockEvent and RetryEvent. It replaces the previous 
+// OnFileTransfer hook, but not handlers added with Subscribe.
 func (cc *CowClient) OnFileTransfer(hook FileTransferFunc) {
-	cc.transferProgressHook = hook
+	var handler EventHandler
+	if hook != nil {
+		handler = func(ev Event) {
+			switch e := ev.(type) {
+			case FileEvent:
+				hook(&e.FileTransfer)
+			case BlockEvent:
+				hook(&e.FileTransfer)
+			case RetryEvent:
+				hook(&e.FileTransfer)
+			}
+		}
+	}
+	cc.setHook(&cc.transferProgressHook, handler)
+}
+
+// OnPushBlockError sets a handler to decide what to do when a block cannot be 
+// uploaded after all retries. Without a handler, the upload fails. In 
+// parallel mode, the handler may be called by several goroutines at once.
+func (cc *CowClient) OnPushBlockError(handler PushBlockErrorHandler) {
+	cc.pushBlockErrorHandler = handler
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var lsMineJSON bool
+
+// lsMineFlags registers the flags of the ls-mine command.
+func lsMineFlags(fs *flag.FlagSet) {
+	clientFlags(fs)
+	fs.BoolVar(&lsMineJSON, "json", false, "Print as JSON")
+}
+
+// listMine runs the ls-mine command, which is the same as transfer list.
+func listMine(args []string) error {
+	if len(args) != 0 {
+		return newUsageError("ls-mine expects no arguments")
+	}
+	return listMyTransfers(lsMineJSON)
+}
+
+// listMyTransfers prints the transfers uploaded by the logged in account. The
+// account session is loaded with -c or -W.
+func listMyTransfers(asJSON bool) error {
+	cc, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	list, err := cc.ListTransfers()
+	if err != nil {
+		return fmt.Errorf("cannot list transfers: %w", err)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(list); err != nil {
+			return err
+		}
+		return saveCookies(cc)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "GUID\tNAME\tSIZE\tFILES\tCREATED\tEXPIRES\tDOWNLOADS\tURL")
+	for _, v := range list.Transfers {
+		expires := "never"
+		if !v.ExpiresAt.IsZero() {
+			expires = v.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\t%d\t%s\n",
+			v.GUID, v.Name, v.Size, v.FileCount, v.CreatedAt.Format(time.RFC3339), expires, v.DownloadCount, v.UniqueURL)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "\nquota: %d of %d bytes used\n", list.QuotaUsed, list.QuotaTotal)
+
+	return saveCookies(cc)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	envPrefix      = "COWPUT_"
+	configEnvVar   = "COWPUT_CONFIG"
+	profileEnvVar  = "COWPUT_PROFILE"
+	defaultProfile = "default"
+	configDirName  = "cowput"
+	configFileName = "config.json"
+)
+
+// configFile is the content of the config file:
+//
+//	{
+//	  "default_profile": "work",
+//	  "profiles": {
+//	    "work": {"threads": 4, "timeout": "30s", "cookie_file": "~/cow.txt"}
+//	  }
+//	}
+type configFile struct {
+	DefaultProfile string                            `json:"default_profile"`
+	Profiles       map[string]map[string]interface{} `json:"profiles"`
+}
+
+// setting is a client option that can be set in a profile, the environment
+// and on the command line.
+type setting struct {
+	// key is the name used in profiles. The environment variable is key in
+	// upper case, prefixed with COWPUT_.
+	key  string
+	flag string
+	// path settings get a leading ~ expanded, from every source
+	path bool
+}
+
+// settings covers every CowClient field. RecordHashes is set by manifest, as
+// hashes are only recorded to be saved there. EventDelivery is left out: the
+// progress output of the commands is not thread-safe, so they always use
+// SerialDelivery.
+var settings = []setting{
+	{"block_size", "b", false},
+	{"threads", "p", false},
+	{"max_memory", "m", false},
+	{"retry", "r", false},
+	{"verify_hash", "S", false},
+	{"naming", "naming", false},
+	{"collision", "collision", false},
+	{"password", "w", false},
+	{"user_agent", "u", false},
+	{"token", "W", false},
+	{"cookie_file", "c", true},
+	{"timeout", "t", false},
+	{"debug", "v", false},
+	{"record", "record", true},
+	{"manifest", "manifest", true},
+	{"api_url", "api-url", false},
+	{"oss_url", "oss-url", false},
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(s.key)
+}
+
+// normalize returns a value of the setting as the flag expects it.
+func (s setting) normalize(value string) string {
+	if s.path {
+		return expandHome(value)
+	}
+	return value
+}
+
+// defaultConfigPath returns ~/.config/cowput/config.json, or the equivalent
+// on the current platform.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, configDirName, configFileName)
+}
+
+// applyConfig fills in every setting not given on the command line, from the
+// environment first, then from the selected profile. Settings that are not
+// found anywhere keep their defaults. Values from every source are normalized
+// the same way.
+func applyConfig(fs *flag.FlagSet, path, profile string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	values, source, err := loadProfile(path, profile)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range settings {
+		f := fs.Lookup(v.flag)
+		// skip settings of other commands
+		if f == nil {
+			continue
+		}
+		if explicit[v.flag] {
+			if s := f.Value.String(); v.normalize(s) != s {
+				if err := fs.Set(v.flag, v.normalize(s)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if envValue, ok := os.LookupEnv(v.env()); ok {
+			if err := fs.Set(v.flag, v.normalize(envValue)); err != nil {
+				return fmt.Errorf("invalid %s: %w", v.env(), err)
+			}
+			continue
+		}
+		if value, ok := values[v.key]; ok {
+			if err := fs.Set(v.flag, v.normalize(fmt.Sprint(value))); err != nil {
+				return fmt.Errorf("invalid %s in %s: %w", v.key, source, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadProfile reads the settings of a profile. If profile is empty, the
+// default profile of the config file is used, if any. A missing config file
+// is fine unless a profile is asked for.
+func loadProfile(path, profile string) (map[string]interface{}, string, error) {
+	if path == "" {
+		return nil, "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && profile == "" {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("cannot read config: %w", err)
+	}
+
+	config := configFile{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// keep numbers as written, for flag.Set
+	dec.UseNumber()
+	if err := dec.Decode(&config); err != nil {
+		return nil, "", fmt.Errorf("cannot parse %s: %w", path, err)
+	}
+
+	explicit := profile != ""
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+	if profile == "" {
+		profile = defaultProfile
+	}
+	values, ok := config.Profiles[profile]
+	if !ok {
+		if explicit || config.DefaultProfile != "" {
+			return nil, "", fmt.Errorf("profile %s not found in %s", profile, path)
+		}
+		return nil, "", nil
+	}
+
+	source := fmt.Sprintf("profile %s", profile)
+	for k := range values {
+		if !isSettingKey(k) {
+			return nil, "", fmt.Errorf("unknown setting %s in %s (expect %s)", k, source, strings.Join(settingKeys(nil), ", "))
+		}
+	}
+	if _, ok := values["password"]; ok {
+		if fi, err := os.Stat(path); err == nil && fi.Mode().Perm()&0077 != 0 {
+			fmt.Fprintf(os.Stderr, "warning: %s holds a password but is readable by others\n", path)
+		}
+	}
+	return values, source, nil
+}
+
+func isSettingKey(key string) bool {
+	for _, v := range settings {
+		if v.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// settingKeys returns the names of settings that fs has flags for, or of all
+// settings if fs is nil.
+func settingKeys(fs *flag.FlagSet) []string {
+	keys := []string{}
+	for _, v := range settings {
+		if fs == nil || fs.Lookup(v.flag) != nil {
+			keys = append(keys, v.key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// expandHome replaces a leading ~ with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
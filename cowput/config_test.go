@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setEnv sets an environment variable for the rest of a test.
+func setEnv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestApplyConfig(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	config := filepath.Join(t.TempDir(), "config.json")
+	content := `{"profiles": {
+		"default": {"threads": 4, "cookie_file": "~/profile.txt"},
+		"work": {"threads": 8},
+		"empty": {}
+	}}`
+	if err := os.WriteFile(config, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		profile string
+		threads int
+		cookies string
+	}{
+		{"defaults", nil, nil, "empty", 1, ""},
+		{"profile", nil, nil, "", 4, filepath.Join(home, "profile.txt")},
+		{"named profile", nil, nil, "work", 8, ""},
+		{"env over profile", nil, map[string]string{"COWPUT_THREADS": "6", "COWPUT_COOKIE_FILE": "~/env.txt"}, "", 6, filepath.Join(home, "env.txt")},
+		{"flags over env", []string{"-p", "2", "-c", "~/flag.txt"}, map[string]string{"COWPUT_THREADS": "6", "COWPUT_COOKIE_FILE": "~/env.txt"}, "", 2, filepath.Join(home, "flag.txt")},
+		{"flag at default", []string{"-p", "1"}, nil, "", 1, filepath.Join(home, "profile.txt")},
+		{"no expansion inside", nil, map[string]string{"COWPUT_COOKIE_FILE": "a/~/b.txt"}, "", 4, "a/~/b.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				setEnv(t, k, v)
+			}
+			threads, cookies := 0, ""
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.IntVar(&threads, "p", 1, "")
+			fs.StringVar(&cookies, "c", "", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := applyConfig(fs, config, tt.profile); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if threads != tt.threads || cookies != tt.cookies {
+				t.Errorf("expected threads %d and cookies %q, got %d and %q", tt.threads, tt.cookies, threads, cookies)
+			}
+		})
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("p", 1, "")
+	if err := applyConfig(fs, config, "missing"); err == nil || !strings.Contains(err.Error(), "profile missing not found") {
+		t.Errorf("expected an error for a missing profile, got %v", err)
+	}
+	setEnv(t, "COWPUT_THREADS", "many")
+	if err := applyConfig(fs, config, ""); err == nil {
+		t.Errorf("expected an error for an invalid environment value")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// sessionDirective starts a new transfer in a file list. Any text after it is
+// the title of the transfer.
+const sessionDirective = "#session"
+
+// fileGroup is the files of a transfer in a file list.
+type fileGroup struct {
+	title string
+	files []string
+}
+
+// loadFileList reads a file list, or stdin if path is "-".
+func loadFileList(path string, nul bool) ([]*fileGroup, error) {
+	if path == "-" {
+		return readFileList(os.Stdin, nul)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readFileList(f, nul)
+}
+
+// readFileList reads paths separated by newlines, or by NUL characters if nul
+// is set. Empty entries are ignored, and sessionDirective starts a new group.
+// In a newline separated list, other entries that start with # are comments,
+// so a path that starts with # must be written as ./#name. NUL separated
+// entries are always paths, because any file name is allowed there.
+func readFileList(r io.Reader, nul bool) ([]*fileGroup, error) {
+	scanner := bufio.NewScanner(r)
+	if nul {
+		scanner.Split(scanNUL)
+	}
+
+	groups := []*fileGroup{{}}
+	for scanner.Scan() {
+		entry := scanner.Text()
+		if !nul {
+			entry = strings.TrimSuffix(entry, "\r")
+		}
+		switch {
+		case entry == "":
+			continue
+		case entry == sessionDirective || strings.HasPrefix(entry, sessionDirective+" "):
+			current := groups[len(groups)-1]
+			group := &fileGroup{title: strings.TrimSpace(strings.TrimPrefix(entry, sessionDirective))}
+			if len(current.files) == 0 {
+				// nothing before the first directive
+				groups[len(groups)-1] = group
+			} else {
+				groups = append(groups, group)
+			}
+		case !nul && strings.HasPrefix(entry, "#"):
+			continue
+		default:
+			current := groups[len(groups)-1]
+			current.files = append(current.files, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read file list: %w", err)
+	}
+
+	result := []*fileGroup{}
+	for _, v := range groups {
+		if len(v.files) > 0 {
+			result = append(result, v)
+		}
+	}
+	return result, nil
+}
+
+// scanNUL is a bufio.SplitFunc for NUL terminated entries, like the output of
+// find -print0.
+func scanNUL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadFileList(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		input  string
+		nul    bool
+		expect []fileGroup
+	}{
+		{
+			name:   "lines",
+			input:  "a\r\n\n# comment\n./#b\nc\n",
+			expect: []fileGroup{{files: []string{"a", "./#b", "c"}}},
+		},
+		{
+			name:  "sessions",
+			input: "#session First\na\n#session\n#session Second\nb\nc",
+			expect: []fileGroup{
+				{title: "First", files: []string{"a"}},
+				{title: "Second", files: []string{"b", "c"}},
+			},
+		},
+		{
+			name:   "files before the first session",
+			input:  "a\n#session Logs\nb\n",
+			expect: []fileGroup{{files: []string{"a"}}, {title: "Logs", files: []string{"b"}}},
+		},
+		{
+			name:  "nul",
+			input: "a b\x00#c\x00line\nbreak\x00\x00#session Logs\x00 d\x00#sessions",
+			nul:   true,
+			expect: []fileGroup{
+				{files: []string{"a b", "#c", "line\nbreak"}},
+				{title: "Logs", files: []string{" d", "#sessions"}},
+			},
+		},
+		{
+			name:   "only comments",
+			input:  "# nothing\n\n",
+			expect: []fileGroup{},
+		},
+	} {
+		groups, err := readFileList(strings.NewReader(test.input), test.nul)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		got := []fileGroup{}
+		for _, v := range groups {
+			got = append(got, *v)
+		}
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%s: expect %+v, got %+v", test.name, test.expect, got)
+		}
+	}
+}
+
+func TestScanNUL(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("a\x00\x00b c\x00d"))
+	scanner.Split(scanNUL)
+	got := []string{}
+	for scanner.Scan() {
+		got = append(got, scanner.Text())
+	}
+	if strings.Join(got, "|") != "a||b c|d" {
+		t.Errorf("unexpected entries: %q", got)
+	}
+}
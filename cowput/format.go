@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"github.com/imacks/cowtransfer"
+)
+
+// listFormats are the output formats of ls, by name.
+var listFormats = map[string]func(w io.Writer, cc *cowtransfer.CowClient, files []cowtransfer.FileInfo) error{
+	"text":     writeText,
+	"aria2":    writeAria2,
+	"wget":     writeWget,
+	"curl":     writeCurl,
+	"csv":      writeCSV,
+	"json":     writeJSON,
+	"metalink": writeMetalink,
+}
+
+func listFormatNames() []string {
+	names := []string{}
+	for k := range listFormats {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeText(w io.Writer, cc *cowtransfer.CowClient, files []cowtransfer.FileInfo) error {
+	for i, v := range files {
+		fmt.Fprintf(w, "index: %d\n", i)
+		fmt.Fprintf(w, "filename: %s\n", v.FileName)
+		fmt.Fprintf(w, "size: %d\n", v.Size)
+		fmt.Fprintf(w, "url: %s\n", v.URL)
+		if v.Error != nil {
+			fmt.Fprintf(w, "error: %s\n", v.Error.Error())
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	return nil
+}
+
+// writeAria2 writes an aria2c input file (aria2c -i), with an output name and
+// the request headers for every file.
+func writeAria2(w io.Writer, cc *cowtransfer.CowClient, files []cowtransfer.FileInfo) error {
+	for _, v := range downloadable(files) {
+		fmt.Fprintf(w, "%s\n", v.URL)
+		fmt.Fprintf(w, "  out=%s\n", outputName(v))
+		for _, h := range headerLines(cc.DownloadHeaders(v)) {
+			fmt.Fprintf(w, "  header=%s\n", h)
+		}
+	}
+	return nil
+}
+
+// writeWget writes a URL list for wget -i. wget reads nothing but URLs from
+// the list, so the command line with the headers to use is printed to stderr.
+// It reads the list from stdin, as the list may be written anywhere. As with
+// curl, the headers of the first file are used.
+func writeWget(w io.Writer, cc *cowtransfer.CowClient, files []cowtransfer.FileInfo) error {
+	list := downloadable(files)
+	if len(list) == 0 {
+		return nil
+	}
+
+	args := []string{"wget", "--content-disposition"}
+	for _, h := range headerLines(cc.DownloadHeaders(list[0])) {
+		args = append(args, "--header="+shellQuote(h))
+	}
+	args = append(args, "-i", "-")
+	fmt.Fprintf(os.Stderr, "pipe the list to: %s\n", strings.Join(args, " "))
+
+	for _, v := range list {
+		fmt.Fprintf(w, "%s\n", v.URL)
+	}
+	return nil
+}
+
+// writeCurl writes a curl config file (curl -K), with an output name for
+// every file. Headers apply to all files in a curl config, so the headers of
+// the first file are used. Files of a transfer share the same host.
+func writeCurl(w io.Writer, cc *cowtransfer.CowClient, files []cowtransfer.FileInfo) error {
+	list := downloadable(files)
+	if len(list) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "location\n")
+	fmt.Fprintf(w, "fail\n")
+	fmt.Fprintf(w, "create-dirs\n")
+	for _, h := range headerLines(cc.DownloadHeaders(list[0])) {
+		fmt.Fprintf(w, "header = %s\n", curlQuote(h))
+	}
+	for _, v := range list {
+		fmt.Fprintf(w, "\n")
+		fmt.Fprintf(w, "url = %s\n", curlQuote(v.URL))
+		fmt.Fprintf(w, "output = %s\n", curlQuote(outputName(v)))
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, cc *cowtransfer.CowClient, files []cowtransfer.FileInfo) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"index", "filename", "size", "url", "error"})
+	for i, v := range files {
+		errMsg := ""
+		if v.Error != nil {
+			errMsg = v.Error.Error()
+		}
+		_ = cw.Write([]string{strconv.Itoa(i), v.FileName, strconv.FormatInt(v.Size, 10), v.URL, errMsg})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// listEntry is a file in the json format.
+type listEntry struct {
+	FileName string            `json:"fileName"`
+	Size     int64             `json:"size"`
+	URL      string            `json:"url,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+func writeJSON(w io.Writer, cc *cowtransfer.CowClient, files []cowtransfer.FileInfo) error {
+	entries := []listEntry{}
+	for _, v := range files {
+		entry := listEntry{FileName: v.FileName, Size: v.Size, URL: v.URL}
+		if v.Error != nil {
+			entry.Error = v.Error.Error()
+		} else {
+			entry.Headers = map[string]string{}
+			h := cc.DownloadHeaders(v)
+			for k := range h {
+				entry.Headers[k] = h.Get(k)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// writeMetalink writes a Metalink 4 document, with hashes from the manifest
+// given with -manifest, if any.
+func writeMetalink(w io.Writer, cc *cowtransfer.CowClient, files []cowtransfer.FileInfo) error {
+	var manifest *cowtransfer.Manifest
+	if manifestPath != "" {
+		var err error
+		manifest, err = cowtransfer.LoadManifest(manifestPath)
+		if err != nil {
+			return fmt.Errorf("cannot load manifest: %w", err)
+		}
+	}
+	return cowtransfer.WriteMetalink(w, files, manifest)
+}
+
+// outputName returns the local name of a file for a download tool, with
+// forward slashes. Unsafe names are reduced to their base name.
+func outputName(fi cowtransfer.FileInfo) string {
+	local, err := fi.LocalPath()
+	if err != nil {
+		return filepath.Base(fi.FileName)
+	}
+	return filepath.ToSlash(local)
+}
+
+// downloadable returns the files that have a download URL.
+func downloadable(files []cowtransfer.FileInfo) []cowtransfer.FileInfo {
+	result := []cowtransfer.FileInfo{}
+	for _, v := range files {
+		if v.Error == nil && v.URL != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// headerLines formats headers as "Name: value", sorted by name.
+func headerLines(h http.Header) []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		lines = append(lines, k+": "+h.Get(k))
+	}
+	return lines
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func curlQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"github.com/imacks/cowtransfer"
+)
+
+func testListFiles() (*cowtransfer.CowClient, []cowtransfer.FileInfo) {
+	cc := cowtransfer.NewClient()
+	cc.UserAgent = `it's "ua"`
+	cc.APIURL = "https://api"
+	files := []cowtransfer.FileInfo{
+		{FileName: "my file.txt", Size: 10, URL: "https://dl/a?x=1&y=2"},
+		{FileName: `x/it's "q".txt`, Size: 2048, URL: "https://dl/b"},
+		{FileName: "bad.txt", Error: errors.New("no url")},
+	}
+	return cc, files
+}
+
+// captureStderr returns what f writes to stderr.
+func captureStderr(t *testing.T, f func()) string {
+	tmp, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+
+	stderr := os.Stderr
+	os.Stderr = tmp
+	defer func() { os.Stderr = stderr }()
+	f()
+
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestListFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+		stderr string
+	}{
+		{"aria2", `https://dl/a?x=1&y=2
+  out=my file.txt
+  header=Referer: https://api
+  header=User-Agent: it's "ua"
+https://dl/b
+  out=x/it's "q".txt
+  header=Referer: https://api
+  header=User-Agent: it's "ua"
+`, ""},
+		{"wget", `https://dl/a?x=1&y=2
+https://dl/b
+`, `pipe the list to: wget --content-disposition --header='Referer: https://api' --header='User-Agent: it'\''s "ua"' -i -
+`},
+		{"curl", `location
+fail
+create-dirs
+header = "Referer: https://api"
+header = "User-Agent: it's \"ua\""
+
+url = "https://dl/a?x=1&y=2"
+output = "my file.txt"
+
+url = "https://dl/b"
+output = "x/it's \"q\".txt"
+`, ""},
+		{"csv", `index,filename,size,url,error
+0,my file.txt,10,https://dl/a?x=1&y=2,
+1,"x/it's ""q"".txt",2048,https://dl/b,
+2,bad.txt,0,,no url
+`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			cc, files := testListFiles()
+			b := &bytes.Buffer{}
+			stderr := captureStderr(t, func() {
+				if err := listFormats[tt.format](b, cc, files); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			})
+			if b.String() != tt.want {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.want, b.String())
+			}
+			if stderr != tt.stderr {
+				t.Errorf("expected stderr %q, got %q", tt.stderr, stderr)
+			}
+		})
+	}
+}
+
+func TestListFormatJSON(t *testing.T) {
+	cc, files := testListFiles()
+	b := &bytes.Buffer{}
+	if err := writeJSON(b, cc, files); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []listEntry{}
+	if err := json.Unmarshal(b.Bytes(), &entries); err != nil {
+		t.Fatalf("cannot parse %s: %v", b.String(), err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if e := entries[1]; e.FileName != `x/it's "q".txt` || e.Size != 2048 || e.URL != "https://dl/b" || e.Headers["User-Agent"] != `it's "ua"` {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if e := entries[2]; e.Error != "no url" || e.Headers != nil {
+		t.Errorf("unexpected entry for a file without URL: %+v", e)
+	}
+}
+
+func TestListFormatMetalink(t *testing.T) {
+	manifestPath = ""
+	cc, files := testListFiles()
+	b := &bytes.Buffer{}
+	if err := writeMetalink(b, cc, files); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<file name="my file.txt">`,
+		`<url>https://dl/a?x=1&amp;y=2</url>`,
+		`<file name="x/it&#39;s &#34;q&#34;.txt">`,
+		`<size>2048</size>`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("expected %s in:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "bad.txt") {
+		t.Errorf("expected no entry for a file without URL:\n%s", b.String())
+	}
+
+	manifestPath = filepath.Join(t.TempDir(), "missing.json")
+	defer func() { manifestPath = "" }()
+	if err := writeMetalink(&bytes.Buffer{}, cc, files); err == nil {
+		t.Errorf("expected an error for a missing manifest")
+	}
+}
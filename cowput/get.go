@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"github.com/imacks/cowtransfer"
+)
+
+var (
+	outDir string
+	listOnly bool
+)
+
+// getFlags registers the flags of the get and resume commands.
+func getFlags(fs *flag.FlagSet) {
+	clientFlags(fs)
+	fs.StringVar(&outDir, "o", ".", "Directory to save files to")
+	fs.BoolVar(&listOnly, "l", false, "List files without downloading (same as ls)")
+}
+
+// getRemoteFiles downloads the files of a transfer, replacing local files
+// with the same name.
+func getRemoteFiles(args []string) error {
+	return downloadTransfer("get", args, false)
+}
+
+// resumeRemoteFiles downloads the files of a transfer, continuing from the
+// end of local files with the same name.
+func resumeRemoteFiles(args []string) error {
+	return downloadTransfer("resume", args, true)
+}
+
+func downloadTransfer(name string, args []string, resume bool) error {
+	if len(args) != 1 {
+		return newUsageError("%s expects exactly 1 link or download code", name)
+	}
+	target := args[0]
+
+	cc, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	files, err := resolveFiles(cc, target)
+	if err != nil {
+		return err
+	}
+
+	if listOnly {
+		_ = writeText(os.Stdout, cc, files)
+		return saveCookies(cc)
+	}
+
+	failed := 0
+	var lastErr error
+	for _, v := range files {
+		if v.Error != nil {
+			lastErr = fmt.Errorf("cannot resolve %s: %w", v.FileName, v.Error)
+			fmt.Fprintf(os.Stderr, "%v\n", lastErr)
+			failed++
+			continue
+		}
+
+		outPath, err := outputPath(v)
+		if err == nil {
+			err = downloadFile(cc, v, outPath, resume)
+		}
+		if err != nil {
+			lastErr = err
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "saved: %s\n", outPath)
+	}
+
+	if err := saveCookies(cc); err != nil {
+		return err
+	}
+	if failed > 0 && failed == len(files) {
+		return lastErr
+	} else if failed > 0 {
+		return &partialError{failed: failed, total: len(files)}
+	}
+	return nil
+}
+
+// resolveFiles returns the files of a transfer given as a download link or a
+// temporary download code. The user is asked for a password if needed.
+func resolveFiles(cc *cowtransfer.CowClient, target string) ([]cowtransfer.FileInfo, error) {
+	files, err := filesWithPassword(cc, func() ([]cowtransfer.FileInfo, error) {
+		if _, err := cowtransfer.ParseLink(target); err == nil {
+			return cc.Files(target)
+		}
+		return cc.FilesByCode(target)
+	})
+	if err != nil {
+		return nil, targetError(target, err)
+	}
+	return files, nil
+}
+
+// targetError explains why a download link or code cannot be resolved.
+func targetError(target string, err error) error {
+	switch {
+	case errors.Is(err, cowtransfer.ErrCodeExpired):
+		return fmt.Errorf("download code %s has expired: %w", target, err)
+	case errors.Is(err, cowtransfer.ErrCodeNotFound):
+		return fmt.Errorf("no transfer found for download code %s: %w", target, err)
+	case errors.Is(err, cowtransfer.ErrDownloadCode):
+		return newUsageError("%s is neither a download link nor a download code", target)
+	default:
+		return fmt.Errorf("cannot resolve %s: %w", target, err)
+	}
+}
+
+// outputPath returns where to save a file in the output directory. Names
+// with directories, like those made by -naming relative, are saved in the
+// same directories.
+func outputPath(fi cowtransfer.FileInfo) (string, error) {
+	local, err := fi.LocalPath()
+	if err != nil {
+		return "", fmt.Errorf("cannot download %s: %w", fi.FileName, err)
+	}
+	outPath := filepath.Join(outDir, local)
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return "", fmt.Errorf("cannot create %s: %w", filepath.Dir(outPath), err)
+	}
+	return outPath, nil
+}
+
+// downloadFile saves a remote file to outPath. If resume is true and outPath
+// exists, the download continues from its end.
+func downloadFile(cc *cowtransfer.CowClient, fi cowtransfer.FileInfo, outPath string, resume bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	offset := int64(0)
+	if resume {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		if st, err := os.Stat(outPath); err == nil {
+			offset = st.Size()
+		}
+	}
+
+	f, err := os.OpenFile(outPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %w", outPath, err)
+	}
+
+	_, err = cc.DownloadFrom(fi, offset, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// keep partial content for resume
+		if !resume {
+			_ = os.Remove(outPath)
+		}
+		return fmt.Errorf("cannot download %s: %w", fi.FileName, err)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/imacks/cowtransfer"
+)
+
+func TestOutputPath(t *testing.T) {
+	outDir = t.TempDir()
+	defer func() { outDir = "." }()
+
+	got, err := outputPath(cowtransfer.FileInfo{FileName: "x/a.txt"})
+	if err != nil || got != filepath.Join(outDir, "x", "a.txt") {
+		t.Errorf("unexpected path %s, %v", got, err)
+	}
+	if st, err := os.Stat(filepath.Join(outDir, "x")); err != nil || !st.IsDir() {
+		t.Errorf("directory not created: %v", err)
+	}
+	for _, name := range []string{"../a.txt", "/tmp/a.txt"} {
+		if _, err := outputPath(cowtransfer.FileInfo{FileName: name}); !errors.Is(err, cowtransfer.ErrUnsafeName) {
+			t.Errorf("%s: expected ErrUnsafeName, got %v", name, err)
+		}
+	}
+}
+
+func TestLocalFilesNaming(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "x/a.txt", "y/a.txt")
+
+	if _, err := localFiles([]string{filepath.Join(dir, "x"), filepath.Join(dir, "y")}, cowtransfer.BaseName); err == nil {
+		t.Errorf("expected base names to collide")
+	}
+	files, err := localFiles([]string{filepath.Join(dir, "x"), filepath.Join(dir, "y")}, cowtransfer.RelativeName)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files["x/a.txt"] != filepath.Join(dir, "x", "a.txt") || files["y/a.txt"] != filepath.Join(dir, "y", "a.txt") {
+		t.Errorf("unexpected files: %v", files)
+	}
+}
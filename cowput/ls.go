@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"github.com/imacks/cowtransfer"
+)
+
+var (
+	listFormat string
+	manifestPath string
+)
+
+// lsFlags registers the flags of the ls command.
+func lsFlags(fs *flag.FlagSet) {
+	clientFlags(fs)
+	fs.StringVar(&listFormat, "format", "text", "Output format: "+strings.Join(listFormatNames(), ", "))
+	fs.StringVar(&manifestPath, "manifest", "", "Manifest saved by put -manifest, for exact sizes and hashes in the metalink format")
+}
+
+// listRemoteFiles prints the files of a transfer, either for reading or as
+// input for a download tool.
+func listRemoteFiles(args []string) error {
+	if len(args) != 1 {
+		return newUsageError("ls expects exactly 1 link or download code")
+	}
+	write, ok := listFormats[listFormat]
+	if !ok {
+		return newUsageError("unknown format %s (expect %s)", listFormat, strings.Join(listFormatNames(), ", "))
+	}
+
+	cc, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	files, err := resolveFiles(cc, args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := write(os.Stdout, cc, files); err != nil {
+		return err
+	}
+	return saveCookies(cc)
+}
+
+// showTransferInfo prints a summary of a transfer. A download code is
+// resolved to its download link.
+func showTransferInfo(args []string) error {
+	if len(args) != 1 {
+		return newUsageError("info expects exactly 1 link or download code")
+	}
+	target := args[0]
+
+	cc, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	link, err := cowtransfer.ParseLink(target)
+	if err != nil {
+		link, err = cc.ResolveCode(target)
+		if err != nil {
+			return targetError(target, err)
+		}
+	}
+
+	files, err := resolveFiles(cc, link.String())
+	if err != nil {
+		return err
+	}
+
+	totalSize := int64(0)
+	unresolved := 0
+	for _, v := range files {
+		totalSize += v.Size
+		if v.Error != nil {
+			unresolved++
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "link: %s\n", link.String())
+	fmt.Fprintf(os.Stdout, "id: %s\n", link.ID)
+	fmt.Fprintf(os.Stdout, "files: %d\n", len(files))
+	fmt.Fprintf(os.Stdout, "size: %d\n", totalSize)
+	if unresolved > 0 {
+		fmt.Fprintf(os.Stdout, "unresolved: %d\n", unresolved)
+	}
+	return saveCookies(cc)
+}
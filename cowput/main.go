@@ -1,172 +1,327 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 	"time"
 	"github.com/imacks/cowtransfer"
 )
 
+// Exit codes. Scripts can rely on these.
+const (
+	exitOK       = 0
+	exitFailure  = 1
+	exitUsage    = 2
+	exitNetwork  = 3
+	exitNotFound = 4
+	exitPartial  = 5
+)
+
 var (
 	blockSize int
 	maxThreads int
+	maxMemory int64
 	timeout time.Duration
 	maxRetry int
 	verifyHash bool
 	uploadPassword string
 	useragent string
 	cookieToken string
+	cookieFile string
+	transferOpts cowtransfer.TransferOptions
+	namingPolicy string
+	collisionPolicy string
+	debugMode bool
+	recordFile string
+	apiURL string
+	ossURL string
+	configPath string
+	profileName string
+	// recorder is shared by all clients when recordFile is set
+	recorder *cowtransfer.HARRecorder
 )
 
-func init() {
-	flag.IntVar(&blockSize, "b", 262144, "Block size for uploading")
-	flag.IntVar(&maxThreads, "p", 1, "Number of concurrent threads")
-	flag.IntVar(&maxRetry, "r", 4, "Max failure retry")
-	flag.BoolVar(&verifyHash, "S", false, "Verify hash for every block")
-	flag.StringVar(&uploadPassword, "w", "", "Upload password")
-	flag.StringVar(&useragent, "u", "", "Useragent string")
-	flag.StringVar(&cookieToken, "W", "", "Custom cookie token pattern")
-	flag.DurationVar(&timeout, "t", 10*time.Second, "Timeout duration")
-
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stdout, "%s %s (%s) %s\n", AppName, Version, GitCommit, AppDesc)
-		fmt.Fprintln(os.Stdout, "")
-		fmt.Fprintf(os.Stdout, "Usage: %s [optional] file1 file2... \n", os.Args[0])
-		fmt.Fprintf(os.Stdout, "       %s [optional] url\n", os.Args[0])
-		fmt.Fprintln(os.Stdout, "")
-		fmt.Fprintln(os.Stdout, "Parameters:")
-		flag.PrintDefaults()
-	}
+// command is a cowput subcommand.
+type command struct {
+	name string
+	// args describes the arguments after the flags, for help text.
+	args string
+	desc string
+	// flags registers the flags of the command, if any.
+	flags func(fs *flag.FlagSet)
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"put", "file...", "Upload files to a new transfer", putFlags, putFiles},
+	{"get", "url|id|code", "Download all files of a transfer", getFlags, getRemoteFiles},
+	{"resume", "url|id|code", "Continue an interrupted download, keeping what is already saved", getFlags, resumeRemoteFiles},
+	{"ls", "url|id|code", "List the files of a transfer, or write a download list for aria2, wget or curl", lsFlags, listRemoteFiles},
+	{"info", "url|id|code", "Show a summary of a transfer", clientFlags, showTransferInfo},
+	{"verify", "url|id|code path...", "Check that local files match the files of a transfer", verifyFlags, verifyTransfer},
+	{"watch", "dir", "Upload new and changed files of a directory as they appear", watchFlags, watchDirectory},
+	{"serve", "", "Run a local REST API to submit and track uploads", serveFlags, serveJobs},
+	{"ls-mine", "", "List the transfers and quota of your account (same as transfer list)", lsMineFlags, listMine},
+	{"transfer", "list|set-password|remove-password|delete|update [flags] [guid]", "List or change the transfers of your account", nil, manageTransfer},
+}
+
+// usageError is returned for invalid command lines.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func newUsageError(format string, args ...interface{}) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+// partialError is returned when some files of a command failed and others
+// succeeded.
+type partialError struct {
+	failed int
+	total  int
+}
+
+func (e *partialError) Error() string {
+	return fmt.Sprintf("%d of %d files failed", e.failed, e.total)
 }
 
 func main() {
-	flag.Parse()
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitUsage)
+	}
 
-	files := flag.Args()
-	if len(files) == 0 {
-		fmt.Fprintf(os.Stderr, "no file specified!\n")
-		os.Exit(1)
+	name, args := os.Args[1], os.Args[2:]
+	switch name {
+	case "-h", "-help", "--help":
+		usage()
+		os.Exit(exitOK)
+	case "-version", "--version", "version":
+		fmt.Fprintf(os.Stdout, "%s %s (%s)\n", AppName, Version, GitCommit)
+		os.Exit(exitOK)
+	case "help":
+		if len(args) == 0 {
+			usage()
+			os.Exit(exitOK)
+		}
+		name, args = args[0], []string{"-h"}
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", name)
+		usage()
+		os.Exit(exitUsage)
 	}
 
-	if len(files) == 1 && (strings.HasPrefix(files[0], "https://") || strings.HasPrefix(files[0], "http://")) {
-		err := listRemoteFiles(files[0])
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
+	fs := newFlagSet(cmd.name, cmd.args, cmd.desc, cmd.flags)
+	if err := parseFlags(fs, args); err != nil {
+		exit(err)
+	}
+	exit(cmd.run(fs.Args()))
+}
+
+func findCommand(name string) *command {
+	for i, v := range commands {
+		if v.name == name {
+			return &commands[i]
 		}
-		os.Exit(0)
 	}
+	return nil
+}
 
-	err := uploadFiles(files)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+func usage() {
+	fmt.Fprintf(os.Stdout, "%s %s (%s) %s\n", AppName, Version, GitCommit, AppDesc)
+	fmt.Fprintln(os.Stdout, "")
+	fmt.Fprintf(os.Stdout, "Usage: %s <command> [flags] [arguments]\n", os.Args[0])
+	fmt.Fprintln(os.Stdout, "")
+	fmt.Fprintln(os.Stdout, "Commands:")
+	for _, v := range commands {
+		fmt.Fprintf(os.Stdout, "  %-9s %s\n", v.name, v.desc)
 	}
-	os.Exit(0)
+	fmt.Fprintln(os.Stdout, "")
+	fmt.Fprintf(os.Stdout, "Run '%s help <command>' for the flags of a command.\n", os.Args[0])
+	fmt.Fprintln(os.Stdout, "")
+	fmt.Fprintln(os.Stdout, "Exit codes:")
+	fmt.Fprintf(os.Stdout, "  %d  success\n", exitOK)
+	fmt.Fprintf(os.Stdout, "  %d  error\n", exitFailure)
+	fmt.Fprintf(os.Stdout, "  %d  invalid command line\n", exitUsage)
+	fmt.Fprintf(os.Stdout, "  %d  network error\n", exitNetwork)
+	fmt.Fprintf(os.Stdout, "  %d  transfer or download code not found\n", exitNotFound)
+	fmt.Fprintf(os.Stdout, "  %d  some files failed\n", exitPartial)
 }
 
-func uploadFiles(files []string) error {
-	for _, v := range files {
-		if strings.HasPrefix(v, "https://") || strings.HasPrefix(v, "http://") {
-			return fmt.Errorf("upload supports local file path only: %s", v)
+// newFlagSet creates the flag set of a command, with help text.
+func newFlagSet(name, args, desc string, register func(fs *flag.FlagSet)) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if register != nil {
+		register(fs)
+	}
+	fs.Usage = func() {
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		synopsis := []string{os.Args[0], name}
+		if hasFlags {
+			synopsis = append(synopsis, "[flags]")
+		}
+		if args != "" {
+			synopsis = append(synopsis, args)
+		}
+		fmt.Fprintf(os.Stdout, "Usage: %s\n", strings.Join(synopsis, " "))
+		fmt.Fprintln(os.Stdout, "")
+		fmt.Fprintln(os.Stdout, desc)
+		if !hasFlags {
+			return
+		}
+		fmt.Fprintln(os.Stdout, "")
+		fmt.Fprintln(os.Stdout, "Flags:")
+		fs.SetOutput(os.Stdout)
+		fs.PrintDefaults()
+		if fs.Lookup("config") != nil {
+			fmt.Fprintln(os.Stdout, "")
+			fmt.Fprintln(os.Stdout, "Settings not given on the command line are read from COWPUT_* environment")
+			fmt.Fprintln(os.Stdout, "variables, then from the config profile. Setting names:")
+			fmt.Fprintf(os.Stdout, "  %s\n", strings.Join(settingKeys(fs), ", "))
 		}
 	}
+	return fs
+}
 
-	if maxRetry < 0 {
-		return fmt.Errorf("max retry must be at least 0")
+// parseFlags parses the command line of a command, then fills in settings
+// from the environment and config profile. Asking for help exits right away.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		// the flag package has printed the error and usage already
+		os.Exit(exitUsage)
 	}
-	if blockSize > 4194304 {
-		return fmt.Errorf("block size out of range")
+
+	if fs.Lookup("config") == nil {
+		return nil
+	}
+	if configPath == "" {
+		configPath = os.Getenv(configEnvVar)
+	}
+	if configPath == "" {
+		configPath = defaultConfigPath()
 	}
-	if maxThreads < 1 {
-		return fmt.Errorf("max retry must be bigger than 0")
+	if profileName == "" {
+		profileName = os.Getenv(profileEnvVar)
 	}
+	if err := applyConfig(fs, configPath, profileName); err != nil {
+		return newUsageError("%v", err)
+	}
+	return nil
+}
 
-	cc := cowtransfer.NewClient()
-	cc.Timeout = timeout
-	cc.MaxRetry = maxRetry
-	cc.VerifyHash = verifyHash
-	cc.BlockSize = blockSize
-	cc.MaxPushBlocks = maxThreads
+// clientFlags registers the flags shared by all commands that talk to the
+// server.
+func clientFlags(fs *flag.FlagSet) {
+	fs.StringVar(&uploadPassword, "w", "", "Upload or download password (or set COWPUT_PASSWORD)")
+	fs.StringVar(&useragent, "u", "", "Useragent string")
+	fs.StringVar(&cookieToken, "W", "", "Custom cookie header, added to the cookies saved with -c")
+	fs.StringVar(&cookieFile, "c", "", "Cookie file to load and save (cookies.txt or JSON)")
+	fs.DurationVar(&timeout, "t", 10*time.Second, "Timeout duration")
+	fs.BoolVar(&debugMode, "v", false, "Trace API requests to stderr")
+	fs.StringVar(&recordFile, "record", "", "Record API requests to a HAR file, with secrets redacted")
+	fs.StringVar(&apiURL, "api-url", "", "Override the Cowtransfer API endpoint")
+	fs.StringVar(&ossURL, "oss-url", "", "Override the OSS upload endpoint")
+	fs.StringVar(&configPath, "config", "", "Config file (or set COWPUT_CONFIG, default "+defaultConfigPath()+")")
+	fs.StringVar(&profileName, "profile", "", "Config profile to use (or set COWPUT_PROFILE)")
+}
 
-	if uploadPassword != "" {
-		cc.Password = uploadPassword
+// exit saves the recording, if any, reports err and exits with the matching
+// exit code. The recording is saved on failure too, because that is when it
+// is needed most.
+func exit(err error) {
+	if recorder != nil {
+		if saveErr := recorder.Save(recordFile); saveErr != nil {
+			fmt.Fprintf(os.Stderr, "cannot save recording: %v\n", saveErr)
+		}
 	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	os.Exit(exitCode(err))
+}
+
+// exitCode maps an error to an exit code.
+func exitCode(err error) int {
+	var ue *usageError
+	var pe *partialError
+	var ne net.Error
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &ue):
+		return exitUsage
+	case errors.As(err, &pe):
+		return exitPartial
+	case errors.Is(err, cowtransfer.ErrDownloadNotFound),
+		errors.Is(err, cowtransfer.ErrDownloadDeleted),
+		errors.Is(err, cowtransfer.ErrCodeNotFound),
+		errors.Is(err, cowtransfer.ErrCodeExpired):
+		return exitNotFound
+	case errors.As(err, &ne):
+		return exitNetwork
+	default:
+		return exitFailure
+	}
+}
+
+// newClient creates a client with the options shared by all commands.
+func newClient() (*cowtransfer.CowClient, error) {
+	cc := cowtransfer.NewClient()
+	cc.Timeout = timeout
 	if useragent != "" {
 		cc.UserAgent = useragent
 	}
 	if cookieToken != "" {
 		cc.Token = cookieToken
 	}
-
-	cc.OnStart(func(s *cowtransfer.UploadSession) {
-		fmt.Fprintf(os.Stdout, "event: session_start\n")
-		fmt.Fprintf(os.Stdout, "upload_token: %s\n", s.UploadToken)
-		fmt.Fprintf(os.Stdout, "transfer_guid: %s\n", s.TransferGUID)
-		fmt.Fprintf(os.Stdout, "file_guid: %s\n", s.FileGUID)
-		fmt.Fprintf(os.Stdout, "url: %s\n", s.UniqueURL)
-		fmt.Fprintf(os.Stdout, "prefix: %s\n", s.Prefix)
-		fmt.Fprintf(os.Stdout, "qrcode: %s\n", s.QRCode)
-		fmt.Fprintf(os.Stdout, "temp_code: %s\n", s.TempCode)
-		fmt.Fprintf(os.Stdout, "\n")
-	})
-	cc.OnStop(func(s *cowtransfer.UploadSession) {
-		fmt.Fprintf(os.Stdout, "event: session_stop\n")
-		fmt.Fprintf(os.Stdout, "upload_token: %s\n", s.UploadToken)
-		fmt.Fprintf(os.Stdout, "transfer_guid: %s\n", s.TransferGUID)
-		fmt.Fprintf(os.Stdout, "file_guid: %s\n", s.FileGUID)
-		fmt.Fprintf(os.Stdout, "url: %s\n", s.UniqueURL)
-		fmt.Fprintf(os.Stdout, "prefix: %s\n", s.Prefix)
-		fmt.Fprintf(os.Stdout, "qrcode: %s\n", s.QRCode)
-		fmt.Fprintf(os.Stdout, "temp_code: %s\n", s.TempCode)
-		fmt.Fprintf(os.Stdout, "\n")
-	})
-	cc.OnFileTransfer(func(fi *cowtransfer.FileTransfer) {
-		fmt.Fprintf(os.Stdout, "event: file_transfer\n")
-		fmt.Fprintf(os.Stdout, "path: %s\n", fi.Path)
-		fmt.Fprintf(os.Stdout, "state: %s\n", fi.State.String())
-		fmt.Fprintf(os.Stdout, "total_size: %d\n", fi.Size)
-		fmt.Fprintf(os.Stdout, "done_size: %d\n", fi.DoneSize)
-		fmt.Fprintf(os.Stdout, "total_blocks: %d\n", fi.Blocks)
-		fmt.Fprintf(os.Stdout, "done_blocks: %d\n", fi.DoneBlocks)
-		fmt.Fprintf(os.Stdout, "block: %d\n", fi.BlockNumber)
-		fmt.Fprintf(os.Stdout, "block_size: %d\n", fi.BlockSize)
-		if fi.Error != nil {
-			fmt.Fprintf(os.Stdout, "retry: %d\n", fi.Retry)
-			fmt.Fprintf(os.Stdout, "retries_left: %d\n", fi.RetriesLeft)
-			fmt.Fprintf(os.Stdout, "error: %s\n", fi.Error.Error())
+	if apiURL != "" {
+		cc.APIURL = strings.TrimSuffix(apiURL, "/")
+	}
+	if ossURL != "" {
+		cc.OSSURL = strings.TrimSuffix(ossURL, "/")
+	}
+	if debugMode {
+		cc.Logger = cowtransfer.NewTextLogger(os.Stderr, cowtransfer.LevelDebug)
+		cc.Debug = true
+	}
+	if recordFile != "" {
+		if recorder == nil {
+			recorder = cowtransfer.NewHARRecorder(nil)
 		}
-		fmt.Fprintf(os.Stdout, "\n")
-	})
-
-
-	dlURL, err := cc.Upload(files...)
-	if err != nil {
-		panic(err)
+		cc.Transport = recorder
 	}
-	fmt.Fprintf(os.Stdout, "link: %s\n", dlURL)
-	return nil
+	if cookieFile != "" {
+		err := cc.Cookies.Load(cookieFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot load cookies: %w", err)
+		}
+	}
+	return cc, nil
 }
 
-func listRemoteFiles(url string) error {
-	cc := cowtransfer.NewClient()
-	
-	files, err := cc.Files(url)
-	if err != nil {
-		return fmt.Errorf("cannot resolve %s: %v\n", url, err)
+// saveCookies writes cookies back to the cookie file, so that the session
+// can be reused next time.
+func saveCookies(cc *cowtransfer.CowClient) error {
+	if cookieFile == "" {
+		return nil
 	}
-
-	for i, v := range files {
-		fmt.Fprintf(os.Stdout, "index: %d\n", i)
-		fmt.Fprintf(os.Stdout, "filename: %s\n", v.FileName)
-		fmt.Fprintf(os.Stdout, "size: %d\n", v.Size)
-		fmt.Fprintf(os.Stdout, "url: %s\n", v.URL)
-		if v.Error != nil {
-			fmt.Fprintf(os.Stdout, "error: %s\n", v.Error.Error())
-		}
-		fmt.Fprintf(os.Stdout, "\n")
+	if err := cc.Cookies.Save(cookieFile); err != nil {
+		return fmt.Errorf("cannot save cookies: %w", err)
 	}
 	return nil
-}
\ No newline at end of file
+}
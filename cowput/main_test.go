@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExitCodeNetwork(t *testing.T) {
+	// a closed server refuses connections
+	ts := httptest.NewServer(nil)
+	ts.Close()
+	files := writeTestFiles(t, t.TempDir(), "a.txt")
+
+	setFlags(t, putFlags, "-api-url", ts.URL, "-oss-url", ts.URL, "-r", "0")
+	if err := putFiles(files); exitCode(err) != exitNetwork {
+		t.Errorf("put: expected exit code %d, got %d for %v", exitNetwork, exitCode(err), err)
+	}
+	// the session opens, but blocks cannot be pushed
+	fake := newFakeUploadServer(t, nil)
+	defer fake.Close()
+	setFlags(t, putFlags, "-api-url", fake.URL, "-oss-url", ts.URL, "-r", "0")
+	if err := putFiles(files); exitCode(err) != exitNetwork {
+		t.Errorf("put: expected exit code %d, got %d for %v", exitNetwork, exitCode(err), err)
+	}
+	setFlags(t, putFlags, "-api-url", fake.URL, "-oss-url", ts.URL, "-r", "0", "-p", "2")
+	if err := putFiles(files); exitCode(err) != exitNetwork {
+		t.Errorf("put -p 2: expected exit code %d, got %d for %v", exitNetwork, exitCode(err), err)
+	}
+
+	// the blocks are pushed, but merging them times out
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/uploads/id") {
+			time.Sleep(200 * time.Millisecond)
+		}
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	setFlags(t, putFlags, "-api-url", slow.URL, "-oss-url", slow.URL, "-r", "0", "-t", "50ms")
+	if err := putFiles(files); exitCode(err) != exitNetwork {
+		t.Errorf("put timeout: expected exit code %d, got %d for %v", exitNetwork, exitCode(err), err)
+	}
+
+	setFlags(t, lsFlags, "-api-url", ts.URL)
+	if err := listRemoteFiles([]string{"https://cowtransfer.com/s/abab0000123456"}); exitCode(err) != exitNetwork {
+		t.Errorf("ls: expected exit code %d, got %d for %v", exitNetwork, exitCode(err), err)
+	}
+}
+
+func TestPutPartial(t *testing.T) {
+	fake := newFakeUploadServer(t, nil)
+	defer fake.Close()
+	dir := t.TempDir()
+	files := writeTestFiles(t, dir, "a/x.txt", "b/x.txt")
+	list := filepath.Join(dir, "list.txt")
+	content := "#session one\n" + files[0] + "\n#session two\n" + filepath.Join(dir, "missing.txt") + "\n"
+	if err := os.WriteFile(list, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second transfer fails
+	setFlags(t, putFlags, "-api-url", fake.URL, "-oss-url", fake.URL, "-from-file", list)
+	if err := putFiles(nil); exitCode(err) != exitPartial {
+		t.Errorf("expected exit code %d, got %d for %v", exitPartial, exitCode(err), err)
+	}
+	// a file is skipped
+	setFlags(t, putFlags, "-api-url", fake.URL, "-oss-url", fake.URL, "-collision", "skip")
+	if err := putFiles(files); exitCode(err) != exitPartial {
+		t.Errorf("expected exit code %d, got %d for %v", exitPartial, exitCode(err), err)
+	}
+	setFlags(t, putFlags, "-api-url", fake.URL, "-oss-url", fake.URL, "-naming", "hash")
+	if err := putFiles(files); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
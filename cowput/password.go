@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"github.com/imacks/cowtransfer"
+	"golang.org/x/term"
+)
+
+const (
+	passwordEnvVar   = "COWPUT_PASSWORD"
+	maxPasswordTries = 3
+)
+
+// passwordReader reads passwords from stdin. A terminal is asked without
+// echo, and piped input is read a line at a time.
+type passwordReader struct {
+	lines *bufio.Reader
+}
+
+func (pr *passwordReader) read() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "password: ")
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr, "")
+		return string(password), err
+	}
+
+	if pr.lines == nil {
+		pr.lines = bufio.NewReader(os.Stdin)
+	}
+	line, err := pr.lines.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// filesWithPassword calls fetch with the password set on cc. If the transfer
+// needs a password that is missing or wrong, one is read from stdin.
+func filesWithPassword(cc *cowtransfer.CowClient, fetch func() ([]cowtransfer.FileInfo, error)) ([]cowtransfer.FileInfo, error) {
+	cc.Password = uploadPassword
+
+	reader := &passwordReader{}
+	for i := 0; ; i++ {
+		files, err := fetch()
+		if !errors.Is(err, cowtransfer.ErrPasswordRequired) && !errors.Is(err, cowtransfer.ErrPasswordIncorrect) {
+			return files, err
+		}
+		if i >= maxPasswordTries {
+			return nil, fmt.Errorf("%w (use -w or %s)", err, passwordEnvVar)
+		}
+
+		if errors.Is(err, cowtransfer.ErrPasswordIncorrect) {
+			fmt.Fprintf(os.Stderr, "incorrect password\n")
+		}
+		password, rerr := reader.read()
+		if rerr != nil {
+			return nil, fmt.Errorf("%w (use -w or %s)", err, passwordEnvVar)
+		}
+		cc.Password = password
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"github.com/imacks/cowtransfer"
+)
+
+var (
+	// reportPath is the report file of put, if any.
+	reportPath string
+	// fileListPath is a file to read paths from, and nulSeparated selects
+	// its format.
+	fileListPath string
+	nulSeparated bool
+	// dryRun prints the upload plan instead of uploading, with an estimate
+	// at planSpeed bytes per second.
+	dryRun bool
+	planSpeed int64
+)
+
+// namingPolicies are the values of -naming.
+var namingPolicies = map[string]cowtransfer.NameFunc{
+	"base":      cowtransfer.BaseName,
+	"relative":  cowtransfer.RelativeName,
+	"hash":      cowtransfer.HashSuffixName,
+	"timestamp": cowtransfer.TimestampName,
+}
+
+// collisionPolicies are the values of -collision.
+var collisionPolicies = map[string]cowtransfer.CollisionPolicy{
+	"fail":   cowtransfer.CollisionFail,
+	"rename": cowtransfer.CollisionRename,
+	"skip":   cowtransfer.CollisionSkip,
+}
+
+// planLatency is the assumed time of an API call in upload estimates.
+const planLatency = 300*time.Millisecond
+
+// putFlags registers the flags of the put command.
+func putFlags(fs *flag.FlagSet) {
+	uploadFlags(fs)
+	fs.StringVar(&manifestPath, "manifest", "", "Save SHA-256 hashes of uploaded files to a manifest file, for ls -format metalink")
+	fs.StringVar(&reportPath, "report", "", "Save the outcome of every file to a report file (JSON, or CSV if it ends with .csv)")
+	fs.StringVar(&fileListPath, "from-file", "", "Read paths to upload from a file, one per line (- for stdin). A \""+sessionDirective+" [title]\" line starts another transfer")
+	fs.BoolVar(&nulSeparated, "0", false, "Paths in the -from-file list are separated by NUL characters, as from find -print0")
+	fs.BoolVar(&dryRun, "n", false, "Print what would be uploaded, without contacting the server")
+	fs.Int64Var(&planSpeed, "speed", 10485760, "Upload speed in bytes per second, for the -n estimate")
+}
+
+// uploadFlags registers the flags shared by all commands that upload.
+func uploadFlags(fs *flag.FlagSet) {
+	clientFlags(fs)
+	fs.IntVar(&blockSize, "b", 4194304, "Block size for uploading (1mb to 1gb)")
+	fs.IntVar(&maxThreads, "p", 1, "Number of concurrent threads")
+	fs.Int64Var(&maxMemory, "m", 33554432, "Max memory for block buffers")
+	fs.IntVar(&maxRetry, "r", 4, "Max failure retry")
+	fs.BoolVar(&verifyHash, "S", false, "Verify hash for every block")
+	fs.StringVar(&transferOpts.Name, "title", "", "Display name of the transfer")
+	fs.StringVar(&transferOpts.Message, "message", "", "Message shown to recipients")
+	fs.IntVar(&transferOpts.ValidDays, "days", 0, "Number of days the transfer stays available (0 is site default)")
+	fs.IntVar(&transferOpts.MaxDownloads, "max-downloads", 0, "Number of downloads allowed (0 is unlimited)")
+	fs.BoolVar(&transferOpts.NotifyOnDownload, "notify", false, "Notify on every download")
+	fs.StringVar(&namingPolicy, "naming", "base", "File names in the transfer: base, relative (path from the uploaded directory), hash (path hash suffix) or timestamp (mtime prefix)")
+	fs.StringVar(&collisionPolicy, "collision", "fail", "When files get the same name: fail, rename (add a -2 suffix) or skip")
+}
+
+// putFiles uploads files to a new transfer and prints its download link. With 
+// -from-file, every group of the list is uploaded to its own transfer.
+func putFiles(files []string) error {
+	groups := []*fileGroup{{files: files}}
+	if fileListPath != "" {
+		if len(files) > 0 {
+			return newUsageError("put takes files from -from-file or arguments, not both")
+		}
+		var err error
+		groups, err = loadFileList(fileListPath, nulSeparated)
+		if err != nil {
+			return err
+		}
+		if len(groups) == 0 {
+			return newUsageError("no files in %s", fileListPath)
+		}
+	} else if nulSeparated {
+		return newUsageError("-0 needs -from-file")
+	}
+	if len(groups[0].files) == 0 {
+		return newUsageError("put expects at least 1 file")
+	}
+	for _, group := range groups {
+		for _, v := range group.files {
+			if strings.HasPrefix(v, "https://") || strings.HasPrefix(v, "http://") {
+				return newUsageError("upload supports local file path only: %s", v)
+			}
+		}
+	}
+
+	cc, err := newUploadClient()
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return printPlans(cc, groups)
+	}
+	cc.RecordHashes = manifestPath != ""
+	// progress output below is not thread-safe
+	cc.EventDelivery = cowtransfer.SerialDelivery
+
+	cc.OnStart(func(s *cowtransfer.UploadSession) {
+		fmt.Fprintf(os.Stdout, "event: session_start\n")
+		fmt.Fprintf(os.Stdout, "upload_token: %s\n", s.UploadToken)
+		fmt.Fprintf(os.Stdout, "transfer_guid: %s\n", s.TransferGUID)
+		fmt.Fprintf(os.Stdout, "file_guid: %s\n", s.FileGUID)
+		fmt.Fprintf(os.Stdout, "url: %s\n", s.UniqueURL)
+		fmt.Fprintf(os.Stdout, "prefix: %s\n", s.Prefix)
+		fmt.Fprintf(os.Stdout, "qrcode: %s\n", s.QRCode)
+		fmt.Fprintf(os.Stdout, "temp_code: %s\n", s.TempCode)
+		fmt.Fprintf(os.Stdout, "\n")
+	})
+	cc.OnStop(func(s *cowtransfer.UploadSession) {
+		fmt.Fprintf(os.Stdout, "event: session_stop\n")
+		fmt.Fprintf(os.Stdout, "upload_token: %s\n", s.UploadToken)
+		fmt.Fprintf(os.Stdout, "transfer_guid: %s\n", s.TransferGUID)
+		fmt.Fprintf(os.Stdout, "file_guid: %s\n", s.FileGUID)
+		fmt.Fprintf(os.Stdout, "url: %s\n", s.UniqueURL)
+		fmt.Fprintf(os.Stdout, "prefix: %s\n", s.Prefix)
+		fmt.Fprintf(os.Stdout, "qrcode: %s\n", s.QRCode)
+		fmt.Fprintf(os.Stdout, "temp_code: %s\n", s.TempCode)
+		fmt.Fprintf(os.Stdout, "\n")
+	})
+	cc.OnFileTransfer(func(fi *cowtransfer.FileTransfer) {
+		fmt.Fprintf(os.Stdout, "event: file_transfer\n")
+		fmt.Fprintf(os.Stdout, "path: %s\n", fi.Path)
+		fmt.Fprintf(os.Stdout, "state: %s\n", fi.State.String())
+		fmt.Fprintf(os.Stdout, "total_size: %d\n", fi.Size)
+		fmt.Fprintf(os.Stdout, "done_size: %d\n", fi.DoneSize)
+		fmt.Fprintf(os.Stdout, "total_blocks: %d\n", fi.Blocks)
+		fmt.Fprintf(os.Stdout, "done_blocks: %d\n", fi.DoneBlocks)
+		fmt.Fprintf(os.Stdout, "block: %d\n", fi.BlockNumber)
+		fmt.Fprintf(os.Stdout, "block_size: %d\n", fi.BlockSize)
+		if fi.Error != nil {
+			fmt.Fprintf(os.Stdout, "retry: %d\n", fi.Retry)
+			fmt.Fprintf(os.Stdout, "retries_left: %d\n", fi.RetriesLeft)
+			fmt.Fprintf(os.Stdout, "error: %s\n", fi.Error.Error())
+		}
+		fmt.Fprintf(os.Stdout, "\n")
+	})
+
+	results := []*cowtransfer.UploadResult{}
+	manifest := &cowtransfer.Manifest{Files: []cowtransfer.ManifestFile{}}
+	// a failed transfer doesn't stop the next ones
+	total, uploaded := 0, 0
+	errs := []error{}
+	for _, group := range groups {
+		opts := transferOpts
+		if group.title != "" {
+			opts.Name = group.title
+		}
+		result, err := cc.UploadWithResult(&opts, group.files...)
+		if result != nil {
+			results = append(results, result)
+			if result.Manifest != nil {
+				manifest.Files = append(manifest.Files, result.Manifest.Files...)
+			}
+			total += len(result.Files)
+			uploaded += result.Uploaded()
+		} else {
+			// the files are not known before the session opens
+			total += len(group.files)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("cannot upload: %w", err))
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "link: %s\n", result.UniqueURL)
+	}
+	// the report is most useful when something failed
+	writePutReport(results)
+
+	if cc.RecordHashes {
+		if err := manifest.Save(manifestPath); err != nil {
+			return fmt.Errorf("cannot save manifest: %w", err)
+		}
+	}
+	if err := saveCookies(cc); err != nil {
+		return err
+	}
+	if uploaded == 0 && len(errs) > 0 {
+		for _, v := range errs[:len(errs)-1] {
+			fmt.Fprintf(os.Stderr, "%v\n", v)
+		}
+		return errs[len(errs)-1]
+	}
+	for _, v := range errs {
+		fmt.Fprintf(os.Stderr, "%v\n", v)
+	}
+	if uploaded < total {
+		return &partialError{failed: total - uploaded, total: total}
+	}
+	return nil
+}
+
+// setNaming sets the naming and collision policies of cc by name.
+func setNaming(cc *cowtransfer.CowClient, naming, collision string) error {
+	nameFunc, ok := namingPolicies[naming]
+	if !ok {
+		return fmt.Errorf("unknown naming policy: %s", naming)
+	}
+	policy, ok := collisionPolicies[collision]
+	if !ok {
+		return fmt.Errorf("unknown collision policy: %s", collision)
+	}
+	cc.NameFunc = nameFunc
+	cc.CollisionPolicy = policy
+	return nil
+}
+
+// printPlans prints the upload plan of every group. It fails if some paths 
+// cannot be uploaded.
+func printPlans(cc *cowtransfer.CowClient, groups []*fileGroup) error {
+	if planSpeed <= 0 {
+		return newUsageError("speed must be bigger than 0")
+	}
+	failed := 0
+	for i, group := range groups {
+		plan, err := cc.Plan(group.files...)
+		if err != nil {
+			return newUsageError("%v", err)
+		}
+		title := group.title
+		if title == "" {
+			title = transferOpts.Name
+		}
+
+		fmt.Fprintf(os.Stdout, "transfer: %d\n", i+1)
+		if title != "" {
+			fmt.Fprintf(os.Stdout, "title: %s\n", title)
+		}
+		for _, v := range plan.Files {
+			fmt.Fprintf(os.Stdout, "file: %s -> %s (%d bytes, %d blocks)\n", v.Path, v.Name, v.Size, v.Blocks)
+		}
+		for _, v := range plan.Collisions {
+			fmt.Fprintf(os.Stdout, "collision: %s: %s\n", v.Name, strings.Join(v.Paths, ", "))
+		}
+		for _, v := range plan.Skipped {
+			fmt.Fprintf(os.Stdout, "skipped: %s: %s\n", v.Path, v.Reason)
+		}
+		fmt.Fprintf(os.Stdout, "total_files: %d\n", len(plan.Files))
+		fmt.Fprintf(os.Stdout, "total_size: %d\n", plan.TotalSize)
+		fmt.Fprintf(os.Stdout, "total_blocks: %d\n", plan.TotalBlocks)
+		fmt.Fprintf(os.Stdout, "api_calls: %d\n", plan.APICalls)
+		fmt.Fprintf(os.Stdout, "estimate: %s at %d bytes/s\n", plan.Estimate(float64(planSpeed), planLatency).Round(time.Second), planSpeed)
+		fmt.Fprintf(os.Stdout, "\n")
+		if err := plan.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "transfer %d would fail: %v\n", i+1, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d transfers would fail", failed, len(groups))
+	}
+	return nil
+}
+
+// writePutReport saves the -report file, if any. Failing to save it doesn't 
+// fail the upload.
+func writePutReport(results []*cowtransfer.UploadResult) {
+	if reportPath == "" || len(results) == 0 {
+		return
+	}
+	if err := writeReport(reportPath, results); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot save report: %v\n", err)
+	}
+}
+
+// newUploadClient checks the flags registered by uploadFlags, and creates a 
+// client with them.
+func newUploadClient() (*cowtransfer.CowClient, error) {
+	if maxRetry < 0 {
+		return nil, newUsageError("max retry must be at least 0")
+	}
+	if blockSize < 1048576 || blockSize > 1073741824 {
+		return nil, newUsageError("block size out of range")
+	}
+	if maxThreads < 1 {
+		return nil, newUsageError("number of threads must be bigger than 0")
+	}
+	if err := transferOpts.Validate(); err != nil {
+		return nil, newUsageError("%v", err)
+	}
+
+	cc, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	cc.MaxRetry = maxRetry
+	cc.VerifyHash = verifyHash
+	cc.BlockSize = blockSize
+	cc.MaxPushBlocks = maxThreads
+	cc.MaxMemory = maxMemory
+	if err := setNaming(cc, namingPolicy, collisionPolicy); err != nil {
+		return nil, newUsageError("%v", err)
+	}
+	if uploadPassword != "" {
+		cc.Password = uploadPassword
+	}
+	return cc, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/imacks/cowtransfer"
+)
+
+// writeReport saves the results of put to path, as CSV if the file name ends
+// with .csv, or else as JSON. The JSON report is always an array, with a
+// result for every session.
+func writeReport(path string, results []*cowtransfer.UploadResult) error {
+	buffer := &bytes.Buffer{}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		if err := writeReportCSV(buffer, results); err != nil {
+			return err
+		}
+	} else {
+		enc := json.NewEncoder(buffer)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, buffer.Bytes(), 0644)
+}
+
+// writeReportCSV writes a row for every file, with the session repeated on
+// each row.
+func writeReportCSV(buffer *bytes.Buffer, results []*cowtransfer.UploadResult) error {
+	cw := csv.NewWriter(buffer)
+	_ = cw.Write([]string{
+		"transfer_guid", "url", "temp_download_code", "started", "finished",
+		"path", "name", "file_guid", "size", "blocks", "retries", "duration",
+		"throughput", "hash", "status", "error",
+	})
+	for _, result := range results {
+		for _, v := range result.Files {
+			errMsg := ""
+			if v.Error != nil {
+				errMsg = v.Error.Error()
+			}
+			_ = cw.Write([]string{
+				result.TransferGUID,
+				result.UniqueURL,
+				result.TempCode,
+				result.Started.Format(time.RFC3339),
+				result.Finished.Format(time.RFC3339),
+				v.Path,
+				v.Name,
+				v.FileGUID,
+				strconv.FormatInt(v.Size, 10),
+				strconv.FormatInt(v.Blocks, 10),
+				strconv.FormatInt(v.Retries, 10),
+				strconv.FormatFloat(v.Duration.Seconds(), 'f', 3, 64),
+				strconv.FormatFloat(v.Throughput(), 'f', 0, 64),
+				v.Hash,
+				string(v.Status),
+				errMsg,
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
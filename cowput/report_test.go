@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"github.com/imacks/cowtransfer"
+)
+
+func TestWriteReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	results := []*cowtransfer.UploadResult{{TransferGUID: "g1", Files: []*cowtransfer.FileResult{}}}
+	if err := writeReport(path, results); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one session is still an array
+	got := []map[string]interface{}{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("report is not an array: %v", err)
+	}
+	if len(got) != 1 || got[0]["transfer_guid"] != "g1" {
+		t.Errorf("unexpected report: %s", data)
+	}
+}
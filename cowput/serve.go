@@ -0,0 +1,701 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/imacks/cowtransfer"
+)
+
+// Job states.
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+var (
+	listenAddr string
+	queueSize int
+	jobWorkers int
+	jobHistory int
+)
+
+// serveFlags registers the flags of the serve command. Upload flags are the
+// defaults of every job.
+func serveFlags(fs *flag.FlagSet) {
+	uploadFlags(fs)
+	fs.StringVar(&listenAddr, "listen", "127.0.0.1:8737", "Address to listen on")
+	fs.IntVar(&queueSize, "queue", 16, "Number of jobs that can wait for a worker")
+	fs.IntVar(&jobWorkers, "workers", 1, "Number of jobs to upload at the same time")
+	fs.IntVar(&jobHistory, "history", 100, "Number of finished jobs to keep, oldest are forgotten first")
+}
+
+// jobRequest is the body of POST /jobs.
+type jobRequest struct {
+	Files   []string    `json:"files"`
+	Options *jobOptions `json:"options"`
+}
+
+// jobOptions override the settings the server was started with. Names match
+// the config file settings.
+type jobOptions struct {
+	BlockSize    *int    `json:"block_size"`
+	Threads      *int    `json:"threads"`
+	MaxMemory    *int64  `json:"max_memory"`
+	Retry        *int    `json:"retry"`
+	VerifyHash   *bool   `json:"verify_hash"`
+	Password     *string `json:"password"`
+	RecordHashes bool    `json:"record_hashes"`
+	Naming       *string `json:"naming"`
+	Collision    *string `json:"collision"`
+	Transfer     *cowtransfer.TransferOptions `json:"transfer"`
+}
+
+// apply sets the options on cc, and returns the transfer options to use.
+func (o *jobOptions) apply(cc *cowtransfer.CowClient) (*cowtransfer.TransferOptions, error) {
+	opts := transferOpts
+	if o == nil {
+		return &opts, nil
+	}
+	if o.BlockSize != nil {
+		if *o.BlockSize < 1048576 || *o.BlockSize > 1073741824 {
+			return nil, fmt.Errorf("block size out of range")
+		}
+		cc.BlockSize = *o.BlockSize
+	}
+	if o.Threads != nil {
+		if *o.Threads < 1 {
+			return nil, fmt.Errorf("number of threads must be bigger than 0")
+		}
+		cc.MaxPushBlocks = *o.Threads
+	}
+	if o.MaxMemory != nil {
+		cc.MaxMemory = *o.MaxMemory
+	}
+	if o.Retry != nil {
+		if *o.Retry < 0 {
+			return nil, fmt.Errorf("max retry must be at least 0")
+		}
+		cc.MaxRetry = *o.Retry
+	}
+	if o.VerifyHash != nil {
+		cc.VerifyHash = *o.VerifyHash
+	}
+	if o.Password != nil {
+		cc.Password = *o.Password
+	}
+	cc.RecordHashes = o.RecordHashes
+	if o.Naming != nil || o.Collision != nil {
+		naming, collision := namingPolicy, collisionPolicy
+		if o.Naming != nil {
+			naming = *o.Naming
+		}
+		if o.Collision != nil {
+			collision = *o.Collision
+		}
+		if err := setNaming(cc, naming, collision); err != nil {
+			return nil, err
+		}
+	}
+	if o.Transfer != nil {
+		if err := o.Transfer.Validate(); err != nil {
+			return nil, err
+		}
+		opts = *o.Transfer
+	}
+	return &opts, nil
+}
+
+// fileProgress is the progress of a file in a job.
+type fileProgress struct {
+	Path     string `json:"path"`
+	State    string `json:"state"`
+	Size     int64  `json:"size"`
+	DoneSize int64  `json:"done_size"`
+	Blocks   int64  `json:"blocks"`
+	DoneBlocks int64 `json:"done_blocks"`
+	Retries  int    `json:"retries"`
+	Error    string `json:"error,omitempty"`
+}
+
+// job is an upload submitted to the server.
+type job struct {
+	id       string
+	files    []string
+	options  *jobOptions
+	mutex    sync.Mutex
+	state    string
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	url      string
+	err      error
+	progress []*fileProgress
+	result   *cowtransfer.UploadResult
+	cancel   context.CancelFunc
+	// subscribers receive server-sent events until the job ends
+	subscribers map[chan sseEvent]bool
+}
+
+// jobView is the JSON form of a job.
+type jobView struct {
+	ID       string          `json:"id"`
+	State    string          `json:"state"`
+	Files    []string        `json:"files"`
+	Created  time.Time       `json:"created"`
+	Started  *time.Time      `json:"started,omitempty"`
+	Finished *time.Time      `json:"finished,omitempty"`
+	URL      string          `json:"url,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Progress []*fileProgress `json:"progress"`
+}
+
+// sseEvent is a server-sent event.
+type sseEvent struct {
+	name string
+	data []byte
+}
+
+// view returns a copy of the job for JSON output.
+func (j *job) view() jobView {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	v := jobView{
+		ID:       j.id,
+		State:    j.state,
+		Files:    j.files,
+		Created:  j.created,
+		URL:      j.url,
+		Progress: []*fileProgress{},
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		v.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		v.Finished = &finished
+	}
+	if j.err != nil {
+		v.Error = j.err.Error()
+	}
+	for _, p := range j.progress {
+		copied := *p
+		v.Progress = append(v.Progress, &copied)
+	}
+	return v
+}
+
+// ended reports whether the job will not change any more.
+func (j *job) ended() bool {
+	return j.state == jobDone || j.state == jobFailed || j.state == jobCanceled
+}
+
+// publish sends an event to all subscribers. Subscribers that are not
+// keeping up miss events, but always get the final state of the job.
+func (j *job) publish(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for ch := range j.subscribers {
+		select {
+		case ch <- sseEvent{name, data}:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel of events, which is closed when the job ends.
+// It returns nil if the job has ended already.
+func (j *job) subscribe() chan sseEvent {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.ended() {
+		return nil
+	}
+	ch := make(chan sseEvent, 64)
+	j.subscribers[ch] = true
+	return ch
+}
+
+func (j *job) unsubscribe(ch chan sseEvent) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.subscribers[ch] {
+		delete(j.subscribers, ch)
+		close(ch)
+	}
+}
+
+// end sets the final state of the job, and closes all subscriptions. A job
+// that has ended already is left as it is.
+func (j *job) end(state string, result *cowtransfer.UploadResult, err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.endLocked(state, result, err)
+}
+
+// endLocked is end for a locked job.
+func (j *job) endLocked(state string, result *cowtransfer.UploadResult, err error) {
+	if j.ended() {
+		return
+	}
+	j.state = state
+	j.result = result
+	j.err = err
+	j.finished = time.Now()
+	for ch := range j.subscribers {
+		delete(j.subscribers, ch)
+		close(ch)
+	}
+}
+
+// fileProgress returns the progress of the file at path. The job must be
+// locked.
+func (j *job) fileProgress(path string) *fileProgress {
+	for _, v := range j.progress {
+		if v.Path == path {
+			return v
+		}
+	}
+	p := &fileProgress{Path: path}
+	j.progress = append(j.progress, p)
+	return p
+}
+
+// onEvent records upload progress and passes it on to subscribers. Upload
+// tokens are not passed on.
+func (j *job) onEvent(ev cowtransfer.Event) {
+	var ft *cowtransfer.FileTransfer
+	switch e := ev.(type) {
+	case cowtransfer.SessionEvent:
+		j.mutex.Lock()
+		j.url = e.UniqueURL
+		j.mutex.Unlock()
+		j.publish("session", map[string]interface{}{
+			"transfer_guid":      e.TransferGUID,
+			"url":                e.UniqueURL,
+			"temp_download_code": e.TempCode,
+			"closed":             e.Closed,
+		})
+		return
+	case cowtransfer.FileEvent:
+		ft = &e.FileTransfer
+	case cowtransfer.BlockEvent:
+		ft = &e.FileTransfer
+	case cowtransfer.RetryEvent:
+		ft = &e.FileTransfer
+	default:
+		return
+	}
+
+	j.mutex.Lock()
+	p := j.fileProgress(ft.Path)
+	p.State = ft.State.String()
+	p.Size = ft.Size
+	p.Blocks = ft.Blocks
+	if ft.DoneSize > p.DoneSize {
+		p.DoneSize = ft.DoneSize
+	}
+	if ft.DoneBlocks > p.DoneBlocks {
+		p.DoneBlocks = ft.DoneBlocks
+	}
+	if ft.State == cowtransfer.RetryBlock {
+		p.Retries++
+	}
+	p.Error = ""
+	if ft.Error != nil {
+		p.Error = ft.Error.Error()
+	}
+	copied := *p
+	j.mutex.Unlock()
+	j.publish("progress", &copied)
+}
+
+// server runs upload jobs submitted over HTTP.
+type server struct {
+	mutex  sync.Mutex
+	jobs   map[string]*job
+	order  []string
+	nextID int
+	queue  chan *job
+	// history is the number of finished jobs to keep
+	history int
+}
+
+// contextTransport sends requests with ctx, so that canceling ctx stops
+// requests in flight.
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.ctx.Err(); err != nil {
+		return nil, err
+	}
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(req.WithContext(t.ctx))
+}
+
+// serveJobs runs the REST API until interrupted.
+func serveJobs(args []string) error {
+	if len(args) != 0 {
+		return newUsageError("serve expects no arguments")
+	}
+	if queueSize < 0 || jobWorkers < 1 || jobHistory < 0 {
+		return newUsageError("queue and history must be at least 0, and workers at least 1")
+	}
+	// check the defaults before taking any job
+	if _, err := newUploadClient(); err != nil {
+		return err
+	}
+	if host, _, err := net.SplitHostPort(listenAddr); err == nil {
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			fmt.Fprintf(os.Stderr, "warning: %s is reachable from other hosts, and the API has no authentication\n", listenAddr)
+		}
+	}
+
+	s := newServer(queueSize, jobWorkers, jobHistory)
+
+	ln, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s.handler()}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		s.cancelAll()
+		_ = srv.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "listening on http://%s\n", ln.Addr())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newServer creates a server and starts its workers.
+func newServer(queueSize, workers, history int) *server {
+	s := &server{
+		jobs:    map[string]*job{},
+		queue:   make(chan *job, queueSize),
+		history: history,
+	}
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			s.listJobs(w, r)
+		case "POST":
+			s.submitJob(w, r)
+		default:
+			writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	// /jobs/{id}, /jobs/{id}/events, /jobs/{id}/result and /jobs/{id}/cancel
+	mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+		j := s.job(parts[0])
+		if j == nil || len(parts) > 2 {
+			writeAPIError(w, http.StatusNotFound, "job not found")
+			return
+		}
+		action := ""
+		if len(parts) == 2 {
+			action = parts[1]
+		}
+
+		switch {
+		case action == "" && r.Method == "GET":
+			writeAPIJSON(w, http.StatusOK, j.view())
+		case action == "" && r.Method == "DELETE", action == "cancel" && r.Method == "POST":
+			s.cancelJob(j)
+			writeAPIJSON(w, http.StatusOK, j.view())
+		case action == "events" && r.Method == "GET":
+			s.streamEvents(w, r, j)
+		case action == "result" && r.Method == "GET":
+			j.mutex.Lock()
+			result, ended := j.result, j.ended()
+			j.mutex.Unlock()
+			if result == nil {
+				if ended {
+					writeAPIError(w, http.StatusNotFound, "job has no result")
+				} else {
+					writeAPIError(w, http.StatusConflict, "job has not finished")
+				}
+				return
+			}
+			writeAPIJSON(w, http.StatusOK, result)
+		default:
+			writeAPIError(w, http.StatusNotFound, "not found")
+		}
+	})
+	return mux
+}
+
+func (s *server) job(id string) *job {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.jobs[id]
+}
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	list := make([]*job, 0, len(s.order))
+	for _, id := range s.order {
+		list = append(list, s.jobs[id])
+	}
+	s.mutex.Unlock()
+
+	views := []jobView{}
+	for _, v := range list {
+		views = append(views, v.view())
+	}
+	writeAPIJSON(w, http.StatusOK, views)
+}
+
+// submitJob checks a job request and queues it. Paths are read by the
+// server, so relative paths are relative to its working directory.
+func (s *server) submitJob(w http.ResponseWriter, r *http.Request) {
+	req := jobRequest{}
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("cannot parse request: %v", err))
+		return
+	}
+	if len(req.Files) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "files is empty")
+		return
+	}
+	for _, v := range req.Files {
+		if _, err := os.Stat(v); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	// catch invalid options now rather than when the job runs
+	if _, err := req.Options.apply(cowtransfer.NewClient()); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	s.nextID++
+	j := &job{
+		id:          strconv.Itoa(s.nextID),
+		files:       req.Files,
+		options:     req.Options,
+		state:       jobQueued,
+		created:     time.Now(),
+		progress:    []*fileProgress{},
+		subscribers: map[chan sseEvent]bool{},
+	}
+	select {
+	case s.queue <- j:
+		s.prune()
+		s.jobs[j.id] = j
+		s.order = append(s.order, j.id)
+		s.mutex.Unlock()
+	default:
+		s.mutex.Unlock()
+		writeAPIError(w, http.StatusServiceUnavailable, "job queue is full")
+		return
+	}
+	writeAPIJSON(w, http.StatusAccepted, j.view())
+}
+
+// prune forgets the oldest finished jobs, so that at most history of them
+// are kept. The server must be locked.
+func (s *server) prune() {
+	ended := map[string]bool{}
+	for _, id := range s.order {
+		j := s.jobs[id]
+		j.mutex.Lock()
+		ended[id] = j.ended()
+		j.mutex.Unlock()
+	}
+	extra := -s.history
+	for _, v := range ended {
+		if v {
+			extra++
+		}
+	}
+
+	order := s.order[:0]
+	for _, id := range s.order {
+		if extra > 0 && ended[id] {
+			delete(s.jobs, id)
+			extra--
+			continue
+		}
+		order = append(order, id)
+	}
+	s.order = order
+}
+
+// work runs queued jobs one at a time.
+func (s *server) work() {
+	for j := range s.queue {
+		s.run(j)
+	}
+}
+
+func (s *server) run(j *job) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	j.mutex.Lock()
+	if j.state != jobQueued {
+		// canceled while waiting
+		j.mutex.Unlock()
+		return
+	}
+	j.state = jobRunning
+	j.started = time.Now()
+	j.cancel = cancel
+	j.mutex.Unlock()
+	j.publish("job", j.view())
+
+	cc, err := newUploadClient()
+	if err != nil {
+		j.end(jobFailed, nil, err)
+		return
+	}
+	opts, err := j.options.apply(cc)
+	if err != nil {
+		j.end(jobFailed, nil, err)
+		return
+	}
+	cc.Transport = &contextTransport{ctx: ctx, transport: cc.Transport}
+	cc.EventDelivery = cowtransfer.SerialDelivery
+	cc.Subscribe(j.onEvent)
+
+	result, err := cc.UploadWithResult(opts, j.files...)
+	switch {
+	case err == nil:
+		j.end(jobDone, result, nil)
+	case ctx.Err() != nil:
+		j.end(jobCanceled, result, ctx.Err())
+	default:
+		j.end(jobFailed, result, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := saveCookies(cc); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+}
+
+// cancelJob stops a running job, or drops a queued one. The job is locked
+// throughout, so that a queued job cannot start in between.
+func (s *server) cancelJob(j *job) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	switch j.state {
+	case jobQueued:
+		j.endLocked(jobCanceled, nil, context.Canceled)
+	case jobRunning:
+		j.cancel()
+	}
+}
+
+func (s *server) cancelAll() {
+	s.mutex.Lock()
+	list := make([]*job, 0, len(s.jobs))
+	for _, v := range s.jobs {
+		list = append(list, v)
+	}
+	s.mutex.Unlock()
+
+	for _, v := range list {
+		s.cancelJob(v)
+	}
+}
+
+// streamEvents sends the progress of a job as server-sent events. The first
+// and last events are "job" events with the whole job.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request, j *job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	writeEvent := func(name string, data []byte) {
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+		flusher.Flush()
+	}
+	writeJob := func() {
+		data, _ := json.Marshal(j.view())
+		writeEvent("job", data)
+	}
+
+	ch := j.subscribe()
+	writeJob()
+	if ch == nil {
+		return
+	}
+	defer j.unsubscribe(ch)
+
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				writeJob()
+				return
+			}
+			writeEvent(ev.name, ev.data)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeAPIJSON(w, status, map[string]string{"error": msg})
+}
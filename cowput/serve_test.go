@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeUploadServer answers the upload API. Every block push waits for a
+// value from gate, or for the request to be canceled.
+func newFakeUploadServer(t *testing.T, gate chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/transfer/preparesend":
+			fmt.Fprint(w, `{"uptoken":"t","transferguid":"g","uniqueurl":"https://cowtransfer.com/s/abab0000123456","prefix":"p"}`)
+		case r.URL.Path == "/transfer/beforeupload":
+			fmt.Fprint(w, `{"fileGuid":"f"}`)
+		case r.URL.Path == "/transfer/uploaded":
+			fmt.Fprint(w, "true")
+		case r.URL.Path == "/transfer/complete":
+			fmt.Fprint(w, `{"tempDownloadCode":"123456","complete":true}`)
+		case strings.HasSuffix(r.URL.Path, "/uploads"):
+			fmt.Fprint(w, `{"uploadId":"id"}`)
+		case r.Method == "PUT":
+			if gate != nil {
+				select {
+				case <-gate:
+				case <-r.Context().Done():
+					return
+				}
+			}
+			fmt.Fprint(w, `{"etag":"e","md5":""}`)
+		case strings.HasSuffix(r.URL.Path, "/uploads/id"):
+			fmt.Fprint(w, `{"hash":"qhash","key":"k"}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// setFlags resets the settings of a command to their defaults, then applies
+// args. The config file and environment are not read.
+func setFlags(t *testing.T, register func(fs *flag.FlagSet), args ...string) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	register(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestFiles creates files with some content in dir, and returns their
+// paths.
+func writeTestFiles(t *testing.T, dir string, names ...string) []string {
+	paths := []string{}
+	for _, v := range names {
+		path := filepath.Join(dir, v)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("data of "+v), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func apiCall(t *testing.T, method, url string, body interface{}, v interface{}) int {
+	data := []byte{}
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s: cannot parse response: %v", method, url, err)
+		}
+	}
+	return response.StatusCode
+}
+
+// waitJob waits until a job is in state.
+func waitJob(t *testing.T, url, id, state string) jobView {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		view := jobView{}
+		apiCall(t, "GET", url+"/jobs/"+id, nil, &view)
+		if view.State == state {
+			return view
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, expected %s", id, view.State, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServeJobs(t *testing.T) {
+	gate := make(chan struct{})
+	fake := newFakeUploadServer(t, gate)
+	defer fake.Close()
+	setFlags(t, serveFlags, "-api-url", fake.URL, "-oss-url", fake.URL, "-r", "0")
+	files := writeTestFiles(t, t.TempDir(), "a.txt")
+
+	s := newServer(1, 1, 1)
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	submit := func(expect int) string {
+		view := jobView{}
+		if status := apiCall(t, "POST", ts.URL+"/jobs", jobRequest{Files: files[:1]}, &view); status != expect {
+			t.Fatalf("expected status %d, got %d", expect, status)
+		}
+		return view.ID
+	}
+
+	// the first job waits for the gate, the second fills the queue
+	running := submit(http.StatusAccepted)
+	waitJob(t, ts.URL, running, jobRunning)
+	queued := submit(http.StatusAccepted)
+	submit(http.StatusServiceUnavailable)
+	if status := apiCall(t, "POST", ts.URL+"/jobs", jobRequest{Files: []string{"/does/not/exist"}}, nil); status != http.StatusBadRequest {
+		t.Errorf("expected bad request for a missing file, got %d", status)
+	}
+
+	view := jobView{}
+	apiCall(t, "POST", ts.URL+"/jobs/"+queued+"/cancel", nil, &view)
+	if view.State != jobCanceled {
+		t.Errorf("expected queued job to be canceled, got %s", view.State)
+	}
+
+	response, err := http.Get(ts.URL + "/jobs/" + running + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	events := bufio.NewScanner(response.Body)
+	readEvent := func() (string, string) {
+		name, data := "", ""
+		for events.Scan() {
+			line := events.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			case line == "":
+				return name, data
+			}
+		}
+		return name, data
+	}
+	if name, data := readEvent(); name != "job" || !strings.Contains(data, `"state":"running"`) {
+		t.Errorf("unexpected first event %s: %s", name, data)
+	}
+
+	gate <- struct{}{}
+	last, lastData := "", ""
+	for name, data := readEvent(); name != ""; name, data = readEvent() {
+		last, lastData = name, data
+	}
+	if last != "job" || !strings.Contains(lastData, `"state":"done"`) {
+		t.Errorf("unexpected last event %s: %s", last, lastData)
+	}
+
+	result := map[string]interface{}{}
+	if status := apiCall(t, "GET", ts.URL+"/jobs/"+running+"/result", nil, &result); status != http.StatusOK || result["transfer_guid"] != "g" {
+		t.Errorf("unexpected result %d: %v", status, result)
+	}
+	// a finished job cannot be canceled
+	apiCall(t, "POST", ts.URL+"/jobs/"+running+"/cancel", nil, &view)
+	if view.State != jobDone {
+		t.Errorf("expected finished job to stay done, got %s", view.State)
+	}
+
+	// a running job is canceled mid upload. The oldest finished job is
+	// forgotten to keep 1.
+	canceled := submit(http.StatusAccepted)
+	waitJob(t, ts.URL, canceled, jobRunning)
+	if status := apiCall(t, "DELETE", ts.URL+"/jobs/"+canceled, nil, nil); status != http.StatusOK {
+		t.Errorf("unexpected status %d", status)
+	}
+	waitJob(t, ts.URL, canceled, jobCanceled)
+
+	list := []jobView{}
+	apiCall(t, "GET", ts.URL+"/jobs", nil, &list)
+	ids := []string{}
+	for _, v := range list {
+		ids = append(ids, v.ID)
+	}
+	if strings.Join(ids, ",") != queued+","+canceled {
+		t.Errorf("unexpected jobs: %v", ids)
+	}
+	if status := apiCall(t, "GET", ts.URL+"/jobs/"+running, nil, nil); status != http.StatusNotFound {
+		t.Errorf("expected forgotten job to be not found, got %d", status)
+	}
+}
+
+func TestServeCancelQueued(t *testing.T) {
+	s := &server{jobs: map[string]*job{}}
+	j := &job{id: "1", state: jobQueued, subscribers: map[chan sseEvent]bool{}}
+	s.cancelJob(j)
+	// the worker picks up the job after it was canceled
+	s.run(j)
+	j.end(jobDone, nil, nil)
+	if j.state != jobCanceled || j.err != context.Canceled {
+		t.Errorf("expected job to stay canceled, got %s, %v", j.state, j.err)
+	}
+}
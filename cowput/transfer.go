@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"github.com/imacks/cowtransfer"
+)
+
+// transferActions describes the actions of the transfer command.
+var transferActions = map[string]string{
+	"list":            "List the transfers uploaded by your account",
+	"set-password":    "Set the download password (given with -w) of a transfer",
+	"remove-password": "Remove the download password of a transfer",
+	"delete":          "Delete a transfer",
+	"update":          "Change how long and how often a transfer can be downloaded. Settings not given are left as they are",
+}
+
+// manageTransfer lists or changes transfers of the logged in account. The
+// cookies used for the upload are required, so this is normally used
+// together with -c.
+func manageTransfer(args []string) error {
+	if len(args) == 0 {
+		return newUsageError("transfer expects one of: list, set-password, remove-password, delete, update")
+	}
+
+	action := args[0]
+	desc, ok := transferActions[action]
+	if !ok {
+		return newUsageError("unknown transfer command: %s", action)
+	}
+
+	var (
+		validDays    int
+		maxDownloads int
+		notify       bool
+		asJSON       bool
+	)
+	argsDesc := "guid"
+	if action == "list" {
+		argsDesc = ""
+	}
+	fs := newFlagSet("transfer "+action, argsDesc, desc, func(fs *flag.FlagSet) {
+		clientFlags(fs)
+		switch action {
+		case "list":
+			fs.BoolVar(&asJSON, "json", false, "Print as JSON")
+		case "update":
+			fs.IntVar(&validDays, "days", 0, "Number of days the transfer stays available")
+			fs.IntVar(&maxDownloads, "max-downloads", 0, "Number of downloads allowed (0 is unlimited)")
+			fs.BoolVar(&notify, "notify", false, "Notify on every download")
+		}
+	})
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	if action == "list" {
+		if fs.NArg() != 0 {
+			return newUsageError("transfer list expects no arguments")
+		}
+		return listMyTransfers(asJSON)
+	}
+	if fs.NArg() != 1 {
+		return newUsageError("transfer %s expects exactly 1 transfer guid", action)
+	}
+
+	// only settings given on the command line are changed
+	change := cowtransfer.SettingsChange{}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "days":
+			change.ValidDays = &validDays
+		case "max-downloads":
+			change.MaxDownloads = &maxDownloads
+		case "notify":
+			change.NotifyOnDownload = &notify
+		}
+	})
+	if action == "update" && change == (cowtransfer.SettingsChange{}) {
+		return newUsageError("transfer update expects at least one of -days, -max-downloads or -notify")
+	}
+
+	cc, err := newClient()
+	if err != nil {
+		return err
+	}
+	transfer := cc.Transfer(fs.Arg(0))
+
+	switch action {
+	case "set-password":
+		if uploadPassword == "" {
+			return newUsageError("specify the new password with -w or %s", passwordEnvVar)
+		}
+		err = transfer.SetPassword(uploadPassword)
+	case "remove-password":
+		err = transfer.RemovePassword()
+	case "delete":
+		err = transfer.Delete()
+	case "update":
+		err = transfer.Change(change)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot %s transfer %s: %w", action, transfer.GUID, err)
+	}
+
+	fmt.Fprintf(os.Stdout, "%s: ok\n", action)
+	return saveCookies(cc)
+}
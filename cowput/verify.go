@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"github.com/imacks/cowtransfer"
+)
+
+// sizeTolerance is how much a remote size may differ from the local size.
+// The server reports sizes in kilobytes with limited precision.
+const sizeTolerance = 1024
+
+var verifyContent bool
+
+// verifyFlags registers the flags of the verify command.
+func verifyFlags(fs *flag.FlagSet) {
+	clientFlags(fs)
+	fs.BoolVar(&verifyContent, "content", false, "Download every file and compare SHA-256 hashes, instead of sizes only")
+	fs.StringVar(&namingPolicy, "naming", "base", "How the files were named by put: base, relative, hash or timestamp")
+}
+
+// verifyTransfer checks that every local file is in a transfer with the same
+// size, or the same content with -content. Directories are searched
+// recursively. Files are matched by their name in the transfer, which -naming
+// gives the same way as put does.
+func verifyTransfer(args []string) error {
+	if len(args) < 2 {
+		return newUsageError("verify expects a link or download code, and at least 1 local path")
+	}
+	target := args[0]
+
+	nameFunc, ok := namingPolicies[namingPolicy]
+	if !ok {
+		return newUsageError("unknown naming policy: %s", namingPolicy)
+	}
+	local, err := localFiles(args[1:], nameFunc)
+	if err != nil {
+		return err
+	}
+
+	cc, err := newClient()
+	if err != nil {
+		return err
+	}
+	remote, err := resolveFiles(cc, target)
+	if err != nil {
+		return err
+	}
+
+	remoteByName := map[string]cowtransfer.FileInfo{}
+	for _, v := range remote {
+		remoteByName[v.FileName] = v
+	}
+
+	names := make([]string, 0, len(local))
+	for k := range local {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	failed := 0
+	for _, name := range names {
+		rf, found := remoteByName[name]
+		status, err := verifyFile(cc, local[name], rf, found)
+		if err != nil {
+			status = fmt.Sprintf("error (%v)", err)
+		}
+		if status != "ok" {
+			failed++
+		}
+		delete(remoteByName, name)
+		fmt.Fprintf(os.Stdout, "%s: %s\n", local[name], status)
+	}
+
+	extra := []string{}
+	for k := range remoteByName {
+		extra = append(extra, k)
+	}
+	sort.Strings(extra)
+	for _, v := range extra {
+		fmt.Fprintf(os.Stdout, "%s: only in transfer\n", v)
+	}
+
+	if err := saveCookies(cc); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return &partialError{failed: failed, total: len(local)}
+	}
+	return nil
+}
+
+// verifyFile compares a local file to a remote file, and returns "ok" if they
+// match, or what is different.
+func verifyFile(cc *cowtransfer.CowClient, path string, remote cowtransfer.FileInfo, found bool) (string, error) {
+	if !found {
+		return "missing from transfer", nil
+	}
+	if remote.Error != nil {
+		return "", remote.Error
+	}
+
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	diff := st.Size() - remote.Size
+	if diff < -sizeTolerance || diff > sizeTolerance {
+		return fmt.Sprintf("size differs (local %d, remote %d)", st.Size(), remote.Size), nil
+	}
+	if !verifyContent {
+		return "ok", nil
+	}
+
+	local, err := cowtransfer.HashFile(path, cc.BlockSize)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := cc.Download(remote, h); err != nil {
+		return "", err
+	}
+	if local.SHA256 != hex.EncodeToString(h.Sum(nil)) {
+		return "content differs", nil
+	}
+	return "ok", nil
+}
+
+// localFiles returns the files under paths, keyed by their name in the
+// transfer.
+func localFiles(paths []string, nameFunc cowtransfer.NameFunc) (map[string]string, error) {
+	result := map[string]string{}
+	var root string
+	add := func(path string) error {
+		name, err := nameFunc(root, path)
+		if err != nil {
+			return err
+		}
+		if prev, ok := result[name]; ok {
+			return newUsageError("%s and %s have the same name", prev, path)
+		}
+		result[name] = path
+		return nil
+	}
+
+	for _, v := range paths {
+		root = v
+		st, err := os.Stat(v)
+		if err != nil {
+			return nil, err
+		}
+		if !st.IsDir() {
+			if err := add(v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err = filepath.Walk(v, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				return add(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
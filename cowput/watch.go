@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"github.com/imacks/cowtransfer"
+)
+
+// watchStateFile is the default state file, in the watched directory. It is
+// hidden, so it is never uploaded.
+const watchStateFile = ".cowput-watch.json"
+
+var (
+	pollInterval time.Duration
+	quietPeriod time.Duration
+	batchWindow time.Duration
+	batchFiles int
+	watchState string
+	postURL string
+	watchAttempts int
+)
+
+// watchFlags registers the flags of the watch command.
+func watchFlags(fs *flag.FlagSet) {
+	uploadFlags(fs)
+	fs.DurationVar(&pollInterval, "interval", 5*time.Second, "How often to look for new or changed files")
+	fs.DurationVar(&quietPeriod, "quiet", 30*time.Second, "How long the size and mtime of a file must stay unchanged before upload")
+	fs.DurationVar(&batchWindow, "window", time.Minute, "How long to collect ready files into the same transfer")
+	fs.IntVar(&batchFiles, "max-files", 0, "Upload as soon as this many files are ready (0 is no limit)")
+	fs.StringVar(&watchState, "state", "", "File that records what was uploaded (default <dir>/"+watchStateFile+")")
+	fs.StringVar(&postURL, "post", "", "POST the result of every transfer as JSON to this URL")
+	fs.IntVar(&watchAttempts, "attempts", 3, "Give up on a file after this many failed uploads, until it changes")
+}
+
+// watchedFile is the state of a file that was uploaded, or that failed too
+// many times. A failed file has an error and no link.
+type watchedFile struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Link     string    `json:"link"`
+	Uploaded time.Time `json:"uploaded"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// watchRecord is the content of the state file. Files are keyed by path
+// relative to the watched directory.
+type watchRecord struct {
+	Files map[string]*watchedFile `json:"files"`
+}
+
+// observation is what the last scan saw of a file.
+type observation struct {
+	size    int64
+	modTime time.Time
+	// since is when size and modTime were first seen
+	since   time.Time
+	// attempts is the number of failed uploads since then
+	attempts int
+}
+
+// watcher polls a directory and uploads files once they stop changing.
+type watcher struct {
+	dir       string
+	statePath string
+	record    *watchRecord
+	seen      map[string]*observation
+	// batch is the ready files waiting for upload, and batchStart is when
+	// the first of them was ready
+	batch      []string
+	batchStart time.Time
+}
+
+// watchDirectory uploads new and changed files of a directory until
+// interrupted.
+func watchDirectory(args []string) error {
+	if len(args) != 1 {
+		return newUsageError("watch expects 1 directory")
+	}
+	if pollInterval <= 0 || quietPeriod < 0 || batchWindow < 0 || batchFiles < 0 || watchAttempts < 1 {
+		return newUsageError("interval and attempts must be positive, and quiet, window and max-files at least 0")
+	}
+	dir := args[0]
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return newUsageError("not a directory: %s", dir)
+	}
+
+	cc, err := newUploadClient()
+	if err != nil {
+		return err
+	}
+
+	w := &watcher{
+		dir:       dir,
+		statePath: watchState,
+		seen:      map[string]*observation{},
+	}
+	if w.statePath == "" {
+		w.statePath = filepath.Join(dir, watchStateFile)
+	}
+	if err := w.load(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "watching %s\n", dir)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := w.scan(time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot scan %s: %v\n", dir, err)
+		}
+		if w.due(time.Now()) {
+			w.upload(cc)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// load reads the state file, if any.
+func (w *watcher) load() error {
+	w.record = &watchRecord{Files: map[string]*watchedFile{}}
+	data, err := os.ReadFile(w.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read state: %w", err)
+	}
+	if err := json.Unmarshal(data, w.record); err != nil {
+		return fmt.Errorf("cannot parse state %s: %w", w.statePath, err)
+	}
+	if w.record.Files == nil {
+		w.record.Files = map[string]*watchedFile{}
+	}
+	return nil
+}
+
+// save writes the state file. It is replaced in one step, so that a crash
+// cannot leave it half written.
+func (w *watcher) save() error {
+	data, err := json.MarshalIndent(w.record, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := w.statePath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.statePath)
+}
+
+// scan looks at every file in the directory, and adds the files that have
+// been quiet long enough and were not uploaded or given up as they are to the
+// batch.
+// Hidden files and directories are left out.
+func (w *watcher) scan(now time.Time) error {
+	found := map[string]bool{}
+	err := filepath.WalkDir(w.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != w.dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || filepath.Clean(path) == filepath.Clean(w.statePath) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			// removed since it was listed
+			return nil
+		}
+
+		found[path] = true
+		obs, ok := w.seen[path]
+		if !ok || obs.size != fi.Size() || !obs.modTime.Equal(fi.ModTime()) {
+			w.seen[path] = &observation{size: fi.Size(), modTime: fi.ModTime(), since: now}
+			return nil
+		}
+		if now.Sub(obs.since) < quietPeriod || w.done(path, obs) || w.inBatch(path) {
+			return nil
+		}
+		if len(w.batch) == 0 {
+			w.batchStart = now
+		}
+		w.batch = append(w.batch, path)
+		return nil
+	})
+
+	for k := range w.seen {
+		if !found[k] {
+			delete(w.seen, k)
+		}
+	}
+	// files that went away or changed again are dropped from the batch
+	batch := []string{}
+	for _, v := range w.batch {
+		if obs, ok := w.seen[v]; ok && now.Sub(obs.since) >= quietPeriod {
+			batch = append(batch, v)
+		}
+	}
+	w.batch = batch
+	return err
+}
+
+// done reports whether the file was uploaded, or given up, with the size and
+// mtime seen in obs.
+func (w *watcher) done(path string, obs *observation) bool {
+	v, ok := w.record.Files[w.key(path)]
+	return ok && v.Size == obs.size && v.ModTime.Equal(obs.modTime)
+}
+
+func (w *watcher) inBatch(path string) bool {
+	for _, v := range w.batch {
+		if v == path {
+			return true
+		}
+	}
+	return false
+}
+
+// key is the name of a file in the state file.
+func (w *watcher) key(path string) string {
+	rel, err := filepath.Rel(w.dir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// due reports whether the batch should be uploaded now.
+func (w *watcher) due(now time.Time) bool {
+	if len(w.batch) == 0 {
+		return false
+	}
+	if batchFiles > 0 && len(w.batch) >= batchFiles {
+		return true
+	}
+	return now.Sub(w.batchStart) >= batchWindow
+}
+
+// upload sends the batch, up to max-files files, to a new transfer. Files
+// that fail, are skipped or collide are tried again in a later batch, until
+// they have failed -attempts times.
+func (w *watcher) upload(cc *cowtransfer.CowClient) {
+	files := w.batch
+	if batchFiles > 0 && len(files) > batchFiles {
+		files = files[:batchFiles]
+	}
+	w.batch = append([]string{}, w.batch[len(files):]...)
+	w.batchStart = time.Now()
+	files = w.dropCollisions(cc, files)
+	if len(files) == 0 {
+		w.saveState()
+		return
+	}
+	sort.Strings(files)
+
+	result, err := cc.UploadWithResult(&transferOpts, files...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot upload %d files: %v\n", len(files), err)
+		for _, v := range files {
+			w.failed(v, err)
+		}
+		w.saveState()
+		return
+	}
+
+	fmt.Fprintf(os.Stdout, "link: %s\n", result.UniqueURL)
+	fmt.Fprintf(os.Stdout, "temp_code: %s\n", result.TempCode)
+	for _, v := range result.Files {
+		fmt.Fprintf(os.Stdout, "%s: %s\n", v.Status, v.Path)
+		obs, ok := w.seen[v.Path]
+		if v.Status != cowtransfer.FileUploaded {
+			w.failed(v.Path, v.Error)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		w.record.Files[w.key(v.Path)] = &watchedFile{
+			Size:     obs.size,
+			ModTime:  obs.modTime,
+			Link:     result.UniqueURL,
+			Uploaded: result.Finished,
+		}
+	}
+	fmt.Fprintf(os.Stdout, "\n")
+
+	w.saveState()
+	if err := saveCookies(cc); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+	if postURL != "" {
+		if err := postResult(postURL, result); err != nil {
+			fmt.Fprintf(os.Stderr, "cannot post result: %v\n", err)
+		}
+	}
+}
+
+// dropCollisions returns files without those that get the same name in the
+// transfer, which are counted as failed. With -collision fail, they would fail
+// the whole transfer, and with it files that are not at fault.
+func (w *watcher) dropCollisions(cc *cowtransfer.CowClient, files []string) []string {
+	if cc.CollisionPolicy != cowtransfer.CollisionFail {
+		return files
+	}
+	plan, err := cc.Plan(files...)
+	if err != nil || len(plan.Collisions) == 0 {
+		return files
+	}
+
+	colliding := map[string]bool{}
+	for _, v := range plan.Collisions {
+		err := fmt.Errorf("%w: %s (%s)", cowtransfer.ErrNameCollision, v.Name, strings.Join(v.Paths, ", "))
+		fmt.Fprintf(os.Stderr, "cannot upload %d files: %v\n", len(v.Paths), err)
+		for _, path := range v.Paths {
+			colliding[path] = true
+			w.failed(path, err)
+		}
+	}
+	result := []string{}
+	for _, v := range files {
+		if !colliding[v] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// failed counts a failed upload of a file. After -attempts failures the file
+// is recorded as failed, and is not tried again until it changes.
+func (w *watcher) failed(path string, err error) {
+	obs, ok := w.seen[path]
+	if !ok {
+		return
+	}
+	obs.attempts++
+	if obs.attempts < watchAttempts {
+		return
+	}
+
+	msg := "failed"
+	if err != nil {
+		msg = err.Error()
+	}
+	fmt.Fprintf(os.Stderr, "giving up on %s after %d attempts: %s\n", path, obs.attempts, msg)
+	w.record.Files[w.key(path)] = &watchedFile{
+		Size:    obs.size,
+		ModTime: obs.modTime,
+		Error:   msg,
+	}
+}
+
+func (w *watcher) saveState() {
+	if err := w.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot save state: %v\n", err)
+	}
+}
+
+// postResult sends the result of a transfer as JSON to url.
+func postResult(url string, result *cowtransfer.UploadResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
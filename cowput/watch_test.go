@@ -0,0 +1,221 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestWatcher(t *testing.T, dir string) *watcher {
+	w := &watcher{
+		dir:       dir,
+		statePath: filepath.Join(dir, watchStateFile),
+		seen:      map[string]*observation{},
+	}
+	if err := w.load(); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func scanAt(t *testing.T, w *watcher, now time.Time) {
+	if err := w.scan(now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchQuietPeriod(t *testing.T) {
+	setFlags(t, watchFlags, "-quiet", "30s")
+	dir := t.TempDir()
+	files := writeTestFiles(t, dir, "a.txt", "sub/b.txt", ".hidden")
+	w := newTestWatcher(t, dir)
+
+	start := time.Now()
+	scanAt(t, w, start)
+	scanAt(t, w, start.Add(29*time.Second))
+	if len(w.batch) != 0 {
+		t.Fatalf("expected no ready files before the quiet period, got %v", w.batch)
+	}
+	scanAt(t, w, start.Add(30*time.Second))
+	if len(w.batch) != 2 {
+		t.Fatalf("expected 2 ready files, got %v", w.batch)
+	}
+
+	// a file that changes again waits for another quiet period
+	if err := os.WriteFile(files[0], []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	scanAt(t, w, start.Add(40*time.Second))
+	if len(w.batch) != 1 || w.batch[0] != files[1] {
+		t.Fatalf("expected only %s to be ready, got %v", files[1], w.batch)
+	}
+	scanAt(t, w, start.Add(70*time.Second))
+	if len(w.batch) != 2 {
+		t.Fatalf("expected 2 ready files, got %v", w.batch)
+	}
+
+	// a removed file leaves the batch
+	if err := os.Remove(files[1]); err != nil {
+		t.Fatal(err)
+	}
+	scanAt(t, w, start.Add(71*time.Second))
+	if len(w.batch) != 1 || w.batch[0] != files[0] {
+		t.Fatalf("expected only %s to be ready, got %v", files[0], w.batch)
+	}
+}
+
+func TestWatchBatch(t *testing.T) {
+	setFlags(t, watchFlags, "-quiet", "0", "-window", "1m", "-max-files", "2")
+	dir := t.TempDir()
+	w := newTestWatcher(t, dir)
+
+	start := time.Now()
+	writeTestFiles(t, dir, "a.txt")
+	scanAt(t, w, start)
+	scanAt(t, w, start)
+	if w.due(start.Add(59 * time.Second)) {
+		t.Errorf("expected the batch to wait for the window")
+	}
+	if !w.due(start.Add(time.Minute)) {
+		t.Errorf("expected the batch to be due after the window")
+	}
+
+	// max-files makes the batch due at once
+	writeTestFiles(t, dir, "b.txt", "c.txt")
+	scanAt(t, w, start.Add(time.Second))
+	scanAt(t, w, start.Add(time.Second))
+	if len(w.batch) != 3 || !w.due(start.Add(time.Second)) {
+		t.Errorf("expected 3 files due, got %v", w.batch)
+	}
+}
+
+func TestWatchUpload(t *testing.T) {
+	fake := newFakeUploadServer(t, nil)
+	defer fake.Close()
+	setFlags(t, watchFlags, "-api-url", fake.URL, "-oss-url", fake.URL, "-r", "0", "-quiet", "0", "-max-files", "1")
+	cc, err := newUploadClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := writeTestFiles(t, dir, "a.txt", "b.txt")
+	w := newTestWatcher(t, dir)
+
+	now := time.Now()
+	scanAt(t, w, now)
+	scanAt(t, w, now)
+	w.upload(cc)
+	if len(w.batch) != 1 || w.batch[0] != files[1] {
+		t.Fatalf("expected %s to wait for the next transfer, got %v", files[1], w.batch)
+	}
+	if v := w.record.Files["a.txt"]; v == nil || v.Link != "https://cowtransfer.com/s/abab0000123456" {
+		t.Fatalf("expected a.txt to be recorded, got %+v", v)
+	}
+
+	// a restart resumes from the state file
+	w = newTestWatcher(t, dir)
+	scanAt(t, w, now)
+	scanAt(t, w, now)
+	if len(w.batch) != 1 || w.batch[0] != files[1] {
+		t.Fatalf("expected only %s to be uploaded after a restart, got %v", files[1], w.batch)
+	}
+	w.upload(cc)
+
+	w = newTestWatcher(t, dir)
+	scanAt(t, w, now)
+	scanAt(t, w, now)
+	if len(w.batch) != 0 {
+		t.Fatalf("expected nothing to upload, got %v", w.batch)
+	}
+	if err := os.WriteFile(files[0], []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	scanAt(t, w, now)
+	scanAt(t, w, now)
+	if len(w.batch) != 1 || w.batch[0] != files[0] {
+		t.Fatalf("expected the changed file to be uploaded again, got %v", w.batch)
+	}
+}
+
+func TestWatchGiveUp(t *testing.T) {
+	fake := newFakeUploadServer(t, nil)
+	defer fake.Close()
+	// both files are named x.txt in the transfer, which fails every time
+	setFlags(t, watchFlags, "-api-url", fake.URL, "-oss-url", fake.URL, "-r", "0", "-quiet", "0", "-window", "0", "-attempts", "2")
+	cc, err := newUploadClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := writeTestFiles(t, dir, "a/x.txt", "b/x.txt")
+	w := newTestWatcher(t, dir)
+
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		scanAt(t, w, now)
+		scanAt(t, w, now)
+		if len(w.batch) != 2 {
+			t.Fatalf("attempt %d: expected 2 files to upload, got %v", i+1, w.batch)
+		}
+		w.upload(cc)
+	}
+	for _, v := range []string{"a/x.txt", "b/x.txt"} {
+		if f := w.record.Files[v]; f == nil || f.Error == "" || f.Link != "" {
+			t.Errorf("expected %s to be recorded as failed, got %+v", v, f)
+		}
+	}
+
+	// failed files are not tried again, even after a restart
+	w = newTestWatcher(t, dir)
+	scanAt(t, w, now)
+	scanAt(t, w, now)
+	if len(w.batch) != 0 {
+		t.Fatalf("expected failed files to be left alone, got %v", w.batch)
+	}
+
+	// until they change
+	if err := os.Rename(files[1], filepath.Join(dir, "b", "y.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(files[0], []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	scanAt(t, w, now)
+	scanAt(t, w, now)
+	if len(w.batch) != 2 {
+		t.Fatalf("expected changed files to be tried again, got %v", w.batch)
+	}
+	w.upload(cc)
+	if f := w.record.Files["a/x.txt"]; f == nil || f.Error != "" || f.Link == "" {
+		t.Errorf("expected a/x.txt to be uploaded, got %+v", f)
+	}
+}
+
+func TestWatchCollision(t *testing.T) {
+	fake := newFakeUploadServer(t, nil)
+	defer fake.Close()
+	setFlags(t, watchFlags, "-api-url", fake.URL, "-oss-url", fake.URL, "-r", "0", "-quiet", "0", "-window", "0", "-attempts", "1")
+	cc, err := newUploadClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "a/x.txt", "b/x.txt", "c.txt")
+	w := newTestWatcher(t, dir)
+
+	now := time.Now()
+	scanAt(t, w, now)
+	scanAt(t, w, now)
+	w.upload(cc)
+
+	// only the files named x.txt are at fault
+	for _, v := range []string{"a/x.txt", "b/x.txt"} {
+		if f := w.record.Files[v]; f == nil || f.Error == "" {
+			t.Errorf("expected %s to be recorded as failed, got %+v", v, f)
+		}
+	}
+	if f := w.record.Files["c.txt"]; f == nil || f.Error != "" || f.Link == "" {
+		t.Errorf("expected c.txt to be uploaded, got %+v", f)
+	}
+}
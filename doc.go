@@ -11,6 +11,20 @@ block that will be associated with the file, and merging of blocks.
 
 This package offers the ability to upload blocks with multi-threading by 
 setting CowClient.MaxPushBlocks. This may not be faster than single threaded 
-upload due to timeouts and retries.
+upload due to timeouts and retries. Blocks that still fail after all retries 
+are pushed again in a recovery pass before the file is merged. If that does 
+not help either, Upload returns BlockErrors listing every failed block.
+
+Progress is reported as events to any number of subscribers (see Subscribe, 
+Events and the On* hooks). By default, subscribers are called by the goroutine 
+that produced the event. With multi-threaded uploads, this means subscribers 
+can run concurrently and block events may arrive out of order. Set 
+CowClient.EventDelivery to SerialDelivery if subscribers are not thread-safe. 
+All events then go through a single dispatcher goroutine, so that:
+
+  - subscribers are never called concurrently;
+  - events arrive in the order they were produced, per file and per session;
+  - DoneBlocks and DoneSize never go backwards for a file;
+  - all events of an upload are delivered before Upload returns.
 */
 package cowtransfer
\ No newline at end of file
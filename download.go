@@ -5,15 +5,19 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
-	"time"
+	"strings"
 )
 
 const (
 	downloadDetailsURL = "%s/transfer/transferdetail?url=%s&treceive=undefined&passcode=%s"
 	downloadConfigURL  = "%s/transfer/download?guid=%s"
 	downloadFilesURL   = "%s/transfer/files?page=%d&guid=%s"
+	downloadByCodeURL  = "%s/transfer/tempdownload?code=%s"
 )
 
 // FileInfo represents information about a remote file.
@@ -24,12 +28,26 @@ type FileInfo struct {
 	Error    error  `json:"error"`
 }
 
+// LocalPath returns FileName as a path relative to a download directory. 
+// Names made by RelativeName keep their directories. An error wrapping 
+// ErrUnsafeName is returned for names that are absolute or go up with "..".
+func (fi FileInfo) LocalPath() (string, error) {
+	name := path.Clean(strings.ReplaceAll(fi.FileName, "\\", "/"))
+	if fi.FileName == "" || name == "." || name == ".." || strings.HasPrefix(name, "../") ||
+		path.IsAbs(name) || filepath.IsAbs(name) || (len(name) > 1 && name[1] == ':') {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeName, fi.FileName)
+	}
+	return filepath.FromSlash(name), nil
+}
+
 // downloadDetailsResponse is expected response from downloadDetailsURL API.
 type downloadDetailsResponse struct {
 	GUID         string                 `json:"guid"`
 	DownloadName string                 `json:"downloadName"`
 	Deleted      bool                   `json:"deleted"`
 	Uploaded     bool                   `json:"uploaded"`
+	NeedPassword bool                   `json:"needPassword"`
+	PasswordOK   bool                   `json:"passwordCorrect"`
 }
 
 type downloadFilesResponse struct {
@@ -43,21 +61,30 @@ type downloadDetailsBlock struct {
 	Size     string `json:"size"`
 }
 
+// downloadByCodeResponse is expected response from downloadByCodeURL API.
+type downloadByCodeResponse struct {
+	UniqueURL    string `json:"uniqueUrl"`
+	Expired      bool   `json:"expired"`
+	Error        bool   `json:"error"`
+	ErrorMessage string `json:"error_message"`
+}
+
 // downloadConfigResponse is expected response from downloadConfigURL API.
 type downloadConfigResponse struct {
 	Link string `json:"link"`
 }
 
-var fileIDRegex = regexp.MustCompile("[0-9a-f]{14}")
-
-// Files return information on all files in a download link.
-func (cc *CowClient) Files(url string) ([]FileInfo, error) {
-	fileID := fileIDRegex.FindString(url)
-	if fileID == "" {
-		return nil, ErrDownloadURL
+// Files return information on all files in a download link. See ParseLink 
+// for supported link formats. Password protected transfers require Password 
+// to be set, otherwise ErrPasswordRequired is returned.
+func (cc *CowClient) Files(link string) ([]FileInfo, error) {
+	parsed, err := ParseLink(link)
+	if err != nil {
+		return nil, err
 	}
+	fileID := parsed.ID
 
-	detailsURL := fmt.Sprintf(downloadDetailsURL, cc.APIURL, fileID, cc.Password)
+	detailsURL := fmt.Sprintf(downloadDetailsURL, cc.APIURL, fileID, url.QueryEscape(cc.Password))
 	responseBytes, err := cc.newFileDownloadRequest(detailsURL, fileID)
 	if err != nil {
 		return nil, err
@@ -68,6 +95,14 @@ func (cc *CowClient) Files(url string) ([]FileInfo, error) {
 		return nil, err
 	}
 
+	// a protected transfer hides its details until the passcode matches
+	if allFiles.NeedPassword && !allFiles.PasswordOK {
+		if cc.Password == "" {
+			return nil, ErrPasswordRequired
+		}
+		return nil, ErrPasswordIncorrect
+	}
+
 	if allFiles.GUID == "" {
 		return nil, ErrDownloadNotFound
 	} else if allFiles.Deleted {
@@ -100,6 +135,132 @@ func (cc *CowClient) Files(url string) ([]FileInfo, error) {
 	return result, nil
 }
 
+var tempCodeRegex = regexp.MustCompile("^[0-9]{6}$")
+
+// ResolveCode resolves a temporary download code to the download link of its 
+// transfer. Returns ErrCodeNotFound if no transfer uses the code or the 
+// server cannot look it up, or ErrCodeExpired if the code is no longer valid.
+func (cc *CowClient) ResolveCode(code string) (Link, error) {
+	code = strings.TrimSpace(code)
+	if !tempCodeRegex.MatchString(code) {
+		return Link{}, fmt.Errorf("%w: %s", ErrDownloadCode, code)
+	}
+
+	responseBytes, err := cc.newFileDownloadRequest(fmt.Sprintf(downloadByCodeURL, cc.APIURL, code), "")
+	if err != nil {
+		return Link{}, err
+	}
+
+	response := new(downloadByCodeResponse)
+	if err := json.Unmarshal(responseBytes, response); err != nil {
+		return Link{}, err
+	}
+
+	if response.Error {
+		msg := response.ErrorMessage
+		if msg == "" {
+			msg = "lookup failed"
+		}
+		return Link{}, fmt.Errorf("%w: code %s: %s", ErrCodeNotFound, code, msg)
+	} else if response.Expired {
+		return Link{}, ErrCodeExpired
+	} else if response.UniqueURL == "" {
+		return Link{}, ErrCodeNotFound
+	}
+
+	return ParseLink(response.UniqueURL)
+}
+
+// FilesByCode return information on all files in the transfer that a 
+// temporary download code refers to.
+func (cc *CowClient) FilesByCode(code string) ([]FileInfo, error) {
+	link, err := cc.ResolveCode(code)
+	if err != nil {
+		return nil, err
+	}
+	return cc.Files(link.String())
+}
+
+// Download writes the content of a remote file to w. It returns the number 
+// of bytes written.
+func (cc *CowClient) Download(fi FileInfo, w io.Writer) (int64, error) {
+	return cc.DownloadFrom(fi, 0, w)
+}
+
+// DownloadFrom writes the content of a remote file to w, starting at offset. 
+// Use it to resume a partial download. If the server ignores the requested 
+// range, the first offset bytes are read and discarded. It returns the number 
+// of bytes written.
+func (cc *CowClient) DownloadFrom(fi FileInfo, offset int64, w io.Writer) (int64, error) {
+	if fi.URL == "" {
+		return 0, fmt.Errorf("no download URL for %s", fi.FileName)
+	}
+
+	req, err := http.NewRequest("GET", fi.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header = cc.DownloadHeaders(fi)
+	// the cookie jar adds cookies by itself
+	req.Header.Del("Cookie")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	// Timeout applies to waiting for the response only, because the body may 
+	// take much longer to arrive
+	client := cc.httpClient()
+	client.Timeout = 0
+	if cc.Transport == nil {
+		client.Transport = &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: cc.Timeout,
+		}
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case offset > 0 && response.StatusCode == http.StatusPartialContent:
+	case offset > 0 && response.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// nothing left to download
+		return 0, nil
+	case response.StatusCode == http.StatusOK:
+		if _, err := io.CopyN(io.Discard, response.Body, offset); err != nil {
+			return 0, err
+		}
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone:
+		return 0, fmt.Errorf("%w: %s", ErrDownloadNotFound, fi.FileName)
+	default:
+		return 0, fmt.Errorf("cannot download %s: %s", fi.FileName, response.Status)
+	}
+	return io.Copy(w, response.Body)
+}
+
+// DownloadHeaders returns the HTTP headers that Download sends for a file,
+// including cookies for its host. Use it to download with other tools.
+func (cc *CowClient) DownloadHeaders(fi FileInfo) http.Header {
+	h := http.Header{}
+	h.Set("User-Agent", cc.UserAgent)
+	h.Set("Referer", cc.APIURL)
+
+	if cc.Cookies != nil {
+		if u, err := url.Parse(fi.URL); err == nil {
+			pairs := []string{}
+			for _, v := range cc.Cookies.Cookies(u) {
+				pairs = append(pairs, v.Name+"="+v.Value)
+			}
+			if len(pairs) > 0 {
+				h.Set("Cookie", strings.Join(pairs, "; "))
+			}
+		}
+	}
+	return h
+}
+
 func (cc *CowClient) getFilesByPage(page int, guid, fileID string) (*downloadFilesResponse, error) {
 	responseBytes, err := cc.newFileDownloadRequest(fmt.Sprintf(downloadFilesURL, cc.APIURL, page, guid), fileID)
 	if err != nil {
@@ -126,20 +287,12 @@ func (cc *CowClient) getFileURL(item *downloadDetailsBlock) FileInfo {
 		return result
 	}
 
-	client := http.Client{Timeout: cc.Timeout}
-	response, err := client.Do(cc.addHeaders(req))
+	_, bodyBytes, err := cc.doRequest(cc.httpClient(), cc.addHeaders(req), nil)
 	if err != nil {
 		result.Error = err
 		return result
 	}
 
-	bodyBytes, err := io.ReadAll(response.Body)
-	if err != nil {
-		result.Error = err
-		return result
-	}
-	_ = response.Body.Close()
-
 	config := new(downloadConfigResponse)
 	if err := json.Unmarshal(bodyBytes, config); err != nil {
 		result.Error = err
@@ -160,24 +313,17 @@ func (cc *CowClient) getFileURL(item *downloadDetailsBlock) FileInfo {
 
 // newFileDownloadRequest is a general wrapper for download related API calls.
 func (cc *CowClient) newFileDownloadRequest(url, fileID string) ([]byte, error) {
-	client := http.Client{Timeout: cc.Timeout}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	cc.addHeaders(req)
 	req.Header.Set("Referer", fmt.Sprintf("%s/s/%s", cc.APIURL, fileID))
-	req.Header.Set("Cookie", fmt.Sprintf(cc.Token, "", time.Now().UnixNano()))
 
-	response, err := client.Do(req)
+	_, bodyBytes, err := cc.doRequest(cc.httpClient(), req, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	bodyBytes, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
-	}	
-	_ = response.Body.Close()
-
 	return bodyBytes, nil
 }
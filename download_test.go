@@ -0,0 +1,165 @@
+package cowtransfer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveCode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("code") {
+		case "123456":
+			fmt.Fprint(w, `{"uniqueUrl":"https://cowtransfer.com/s/abab0000123456"}`)
+		case "654321":
+			fmt.Fprint(w, `{"uniqueUrl":"https://cowtransfer.com/s/abab0000123456","expired":true}`)
+		case "111111":
+			fmt.Fprint(w, `{"uniqueUrl":"","error":true,"error_message":"too many requests"}`)
+		case "222222":
+			fmt.Fprint(w, `{"uniqueUrl":"","error":true}`)
+		default:
+			fmt.Fprint(w, `{"uniqueUrl":""}`)
+		}
+	}))
+	defer ts.Close()
+
+	cc := NewClient()
+	cc.APIURL = ts.URL
+
+	link, err := cc.ResolveCode("123456")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link.ID != "abab0000123456" {
+		t.Errorf("expected ID abab0000123456, got %s", link.ID)
+	}
+
+	if _, err := cc.ResolveCode("654321"); !errors.Is(err, ErrCodeExpired) {
+		t.Errorf("expected ErrCodeExpired, got %v", err)
+	}
+	if _, err := cc.ResolveCode("111111"); !errors.Is(err, ErrCodeNotFound) || !strings.Contains(err.Error(), "code 111111: too many requests") {
+		t.Errorf("expected ErrCodeNotFound with the server error message, got %v", err)
+	}
+	if _, err := cc.ResolveCode("222222"); !errors.Is(err, ErrCodeNotFound) || !strings.HasSuffix(err.Error(), "code 222222: lookup failed") {
+		t.Errorf("expected ErrCodeNotFound with a fixed message, got %v", err)
+	}
+	if _, err := cc.ResolveCode("000000"); !errors.Is(err, ErrCodeNotFound) {
+		t.Errorf("expected ErrCodeNotFound, got %v", err)
+	}
+	if _, err := cc.ResolveCode("abc"); !errors.Is(err, ErrDownloadCode) {
+		t.Errorf("expected ErrDownloadCode, got %v", err)
+	}
+}
+
+func TestFilesPassword(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/transfer/transferdetail":
+			if r.URL.Query().Get("passcode") == "p@ss word" {
+				fmt.Fprint(w, `{"guid":"g1","uploaded":true,"needPassword":true,"passwordCorrect":true}`)
+				return
+			}
+			fmt.Fprint(w, `{"needPassword":true}`)
+		case "/transfer/files":
+			fmt.Fprint(w, `{"transferFileDtos":[],"totalPages":1}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	cc := NewClient()
+	cc.APIURL = ts.URL
+	link := "https://cowtransfer.com/s/abab0000123456"
+
+	if _, err := cc.Files(link); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("expected ErrPasswordRequired, got %v", err)
+	}
+
+	cc.Password = "wrong"
+	if _, err := cc.Files(link); !errors.Is(err, ErrPasswordIncorrect) {
+		t.Errorf("expected ErrPasswordIncorrect, got %v", err)
+	}
+
+	cc.Password = "p@ss word"
+	if _, err := cc.Files(link); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestDownloadFrom(t *testing.T) {
+	content := "0123456789"
+	ignoreRange := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ignoreRange {
+			fmt.Fprint(w, content)
+			return
+		}
+		http.ServeContent(w, r, "f", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	cc := NewClient()
+	fi := FileInfo{FileName: "f", URL: ts.URL}
+	for _, ignoreRange = range []bool{false, true} {
+		for _, offset := range []int64{0, 4, 10} {
+			out := &bytes.Buffer{}
+			n, err := cc.DownloadFrom(fi, offset, out)
+			if err != nil {
+				t.Fatalf("offset %d: unexpected error: %v", offset, err)
+			}
+			if out.String() != content[offset:] || n != int64(len(content))-offset {
+				t.Errorf("offset %d, ignore range %v: got %d bytes %q", offset, ignoreRange, n, out.String())
+			}
+		}
+	}
+}
+
+func TestDownloadHeaders(t *testing.T) {
+	cc := NewClient()
+	cc.Cookies.Add(Cookie{Domain: "cdn.example.com", Path: "/", Name: "a", Value: "1"})
+	h := cc.DownloadHeaders(FileInfo{URL: "https://cdn.example.com/f?x=1"})
+	if h.Get("Referer") != cc.APIURL || h.Get("User-Agent") != cc.UserAgent {
+		t.Errorf("unexpected headers: %v", h)
+	}
+	if h.Get("Cookie") != "a=1" {
+		t.Errorf("expect cookie a=1, got %q", h.Get("Cookie"))
+	}
+
+	h = cc.DownloadHeaders(FileInfo{URL: "https://other.example.com/f"})
+	if h.Get("Cookie") != "" {
+		t.Errorf("cookie sent to other host: %q", h.Get("Cookie"))
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	for name, expect := range map[string]string{
+		"a.jpg":             "a.jpg",
+		"photos/2020/a.jpg": filepath.Join("photos", "2020", "a.jpg"),
+		"photos/../a.jpg":   "a.jpg",
+		`photos\a.jpg`:      filepath.Join("photos", "a.jpg"),
+		"":                  "",
+		"..":                "",
+		"../a.jpg":          "",
+		"photos/../../a":    "",
+		"/etc/passwd":       "",
+		`C:\a.jpg`:          "",
+	} {
+		got, err := FileInfo{FileName: name}.LocalPath()
+		if expect == "" {
+			if !errors.Is(err, ErrUnsafeName) {
+				t.Errorf("%q: expected ErrUnsafeName, got %q, %v", name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != expect {
+			t.Errorf("%q: expect %q, got %q, %v", name, expect, got, err)
+		}
+	}
+}
@@ -1,6 +1,11 @@
 package cowtransfer
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 var (
 	ErrInvalidResponse = errors.New("invalid response from endpoint")
@@ -9,4 +14,94 @@ var (
 	ErrDownloadNotFound = errors.New("download not found")
 	ErrDownloadDeleted = errors.New("download is already deleted")
 	ErrUploadInProgress = errors.New("upload in progress")
-)
\ No newline at end of file
+	ErrBlockSize = errors.New("invalid block size")
+	ErrDownloadCode = errors.New("invalid download code")
+	ErrCodeNotFound = errors.New("download code not found")
+	ErrCodeExpired = errors.New("download code has expired")
+	ErrPasswordRequired = errors.New("download is password protected")
+	ErrPasswordIncorrect = errors.New("incorrect download password")
+	ErrNotLoggedIn = errors.New("not logged in")
+	ErrTransferOptions = errors.New("invalid transfer options")
+	ErrNotRecorded = errors.New("request not found in HAR")
+	ErrNameCollision = errors.New("file name used more than once")
+	ErrUnsafeName = errors.New("unsafe file name")
+	// ErrRetryBlock is returned by a PushBlockErrorHandler to retry the 
+	// block again with a fresh retry budget.
+	ErrRetryBlock = errors.New("retry block")
+	// ErrSkipFile is returned by a PushBlockErrorHandler to give up on the 
+	// current file and continue with the next.
+	ErrSkipFile = errors.New("skip file")
+	// ErrAbortSession is returned by a PushBlockErrorHandler to give up on 
+	// the whole upload. Any other error has the same effect.
+	ErrAbortSession = errors.New("upload aborted")
+)
+
+// retryBlockError asks for a block to be retried after a delay.
+type retryBlockError struct {
+	wait time.Duration
+}
+
+func (e *retryBlockError) Error() string {
+	return fmt.Sprintf("retry block after %v", e.wait)
+}
+
+func (e *retryBlockError) Is(target error) bool {
+	return target == ErrRetryBlock
+}
+
+// RetryBlockAfter is returned by a PushBlockErrorHandler to retry the block 
+// again with a fresh retry budget after waiting for d.
+func RetryBlockAfter(d time.Duration) error {
+	return &retryBlockError{wait: d}
+}
+
+// BlockError is the last error encountered while pushing a block.
+type BlockError struct {
+	// Path to file on local filesystem.
+	Path string
+	// Part is the block index (1-based).
+	Part int64
+	// Err is the last error encountered.
+	Err error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("cannot push block %d of %s: %v", e.Part, e.Path, e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// BlockErrors is returned when one or more blocks of a file cannot be pushed, 
+// even after a recovery pass.
+type BlockErrors []*BlockError
+
+func (e BlockErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, v := range e {
+		msgs[i] = v.Error()
+	}
+	return fmt.Sprintf("%d blocks failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the block errors matches target.
+func (e BlockErrors) Is(target error) bool {
+	for _, v := range e {
+		if errors.Is(v, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first block error that matches target, so that errors.As can 
+// reach the cause of a block failure, like a net.Error.
+func (e BlockErrors) As(target interface{}) bool {
+	for _, v := range e {
+		if errors.As(v, target) {
+			return true
+		}
+	}
+	return false
+}
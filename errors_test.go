@@ -0,0 +1,28 @@
+package cowtransfer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestBlockErrors(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	err := fmt.Errorf("upload failed: %w", BlockErrors{
+		{Path: "a", Part: 1, Err: ErrSkipFile},
+		{Path: "a", Part: 2, Err: fmt.Errorf("push: %w", dialErr)},
+	})
+
+	if !errors.Is(err, ErrSkipFile) {
+		t.Errorf("expected errors.Is to find ErrSkipFile")
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || ne != dialErr {
+		t.Errorf("expected errors.As to find the net.Error, got %v", ne)
+	}
+	var be *BlockError
+	if !errors.As(err, &be) || be.Part != 1 {
+		t.Errorf("expected the first BlockError, got %v", be)
+	}
+}
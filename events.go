@@ -0,0 +1,287 @@
+package cowtransfer
+
+import (
+	"context"
+	"sync"
+)
+
+// eventBufferSize is the channel buffer size used by Events.
+const eventBufferSize = 256
+
+// Event is an upload progress event. It is one of SessionEvent, FileEvent,
+// BlockEvent or RetryEvent.
+type Event interface {
+	event()
+}
+
+// SessionEvent is sent when an upload session is opened or closed.
+type SessionEvent struct {
+	UploadSession
+	// Closed is false when the session is opened, and true when it is closed.
+	Closed bool
+}
+
+// FileEvent is sent when a file transfer changes state: InitTransfer,
+// ConfirmUpload, FinishTransfer and SkipTransfer.
+type FileEvent struct {
+	FileTransfer
+}
+
+// BlockEvent is sent when a block is pushed: DoBlock, DoneBlock and
+// FailBlock.
+type BlockEvent struct {
+	FileTransfer
+}
+
+// RetryEvent is sent before a block push is retried (RetryBlock).
+type RetryEvent struct {
+	FileTransfer
+}
+
+func (SessionEvent) event() {}
+func (FileEvent) event()    {}
+func (BlockEvent) event()   {}
+func (RetryEvent) event()   {}
+
+// EventHandler receives events from Subscribe.
+type EventHandler func(ev Event)
+
+// EventDelivery selects how events reach subscribers.
+type EventDelivery int
+const (
+	// DirectDelivery calls subscribers from the goroutine that produces the 
+	// event. When MaxPushBlocks is more than 1, subscribers may be called 
+	// concurrently, and block events may arrive out of order.
+	DirectDelivery EventDelivery = iota
+	// SerialDelivery passes all events of an upload through a single 
+	// dispatcher goroutine. Subscribers are never called concurrently, events 
+	// arrive in the order they were produced, and DoneBlocks and DoneSize 
+	// never go backwards for a file. All events are delivered before Upload 
+	// returns.
+	SerialDelivery
+)
+
+// eventBus delivers events to all subscribers. The zero value is ready to
+// use.
+type eventBus struct {
+	mutex    sync.RWMutex
+	nextID   int
+	handlers map[int]EventHandler
+	// serial delivery queue, shared by concurrent uploads
+	queueMutex sync.Mutex
+	queue      chan Event
+	queueUsers int
+	queueDone  chan struct{}
+}
+
+func (eb *eventBus) subscribe(handler EventHandler) func() {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	if eb.handlers == nil {
+		eb.handlers = map[int]EventHandler{}
+	}
+	id := eb.nextID
+	eb.nextID++
+	eb.handlers[id] = handler
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			eb.mutex.Lock()
+			defer eb.mutex.Unlock()
+			delete(eb.handlers, id)
+		})
+	}
+}
+
+// active returns true if there is at least 1 subscriber. Use it to avoid
+// preparing events that nobody listens to.
+func (eb *eventBus) active() bool {
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+
+	return len(eb.handlers) > 0
+}
+
+func (eb *eventBus) publish(ev Event) {
+	eb.queueMutex.Lock()
+	if eb.queue != nil {
+		eb.queue <- ev
+		eb.queueMutex.Unlock()
+		return
+	}
+	eb.queueMutex.Unlock()
+
+	eb.deliver(ev)
+}
+
+func (eb *eventBus) deliver(ev Event) {
+	eb.mutex.RLock()
+	handlers := make([]EventHandler, 0, len(eb.handlers))
+	for _, v := range eb.handlers {
+		handlers = append(handlers, v)
+	}
+	eb.mutex.RUnlock()
+
+	for _, v := range handlers {
+		v(ev)
+	}
+}
+
+// startSerial switches to serial delivery until a matching stopSerial call.
+func (eb *eventBus) startSerial() {
+	eb.queueMutex.Lock()
+	defer eb.queueMutex.Unlock()
+
+	eb.queueUsers++
+	if eb.queue != nil {
+		return
+	}
+	eb.queue = make(chan Event, eventBufferSize)
+	eb.queueDone = make(chan struct{})
+	go eb.dispatch(eb.queue, eb.queueDone)
+}
+
+// stopSerial waits until all queued events are delivered, then switches back 
+// to direct delivery when no other upload needs serial delivery.
+func (eb *eventBus) stopSerial() {
+	eb.queueMutex.Lock()
+	defer eb.queueMutex.Unlock()
+
+	eb.queueUsers--
+	if eb.queueUsers > 0 {
+		return
+	}
+	close(eb.queue)
+	<-eb.queueDone
+	eb.queue = nil
+	eb.queueDone = nil
+}
+
+// dispatch delivers queued events in order. Progress counters of each file 
+// are kept from going backwards, because parallel block workers may queue 
+// their events in a different order than they counted them.
+func (eb *eventBus) dispatch(queue chan Event, done chan struct{}) {
+	type progress struct {
+		blocks int64
+		size   int64
+	}
+	files := map[string]*progress{}
+
+	clamp := func(ft *FileTransfer) {
+		p, ok := files[ft.Path]
+		if !ok || ft.State == InitTransfer {
+			p = &progress{}
+			files[ft.Path] = p
+		}
+		if ft.DoneBlocks < p.blocks {
+			ft.DoneBlocks = p.blocks
+		}
+		if ft.DoneSize < p.size {
+			ft.DoneSize = p.size
+		}
+		p.blocks, p.size = ft.DoneBlocks, ft.DoneSize
+		if ft.State == FinishTransfer || ft.State == SkipTransfer {
+			delete(files, ft.Path)
+		}
+	}
+
+	for ev := range queue {
+		switch e := ev.(type) {
+		case FileEvent:
+			clamp(&e.FileTransfer)
+			ev = e
+		case BlockEvent:
+			clamp(&e.FileTransfer)
+			ev = e
+		case RetryEvent:
+			clamp(&e.FileTransfer)
+			ev = e
+		}
+		eb.deliver(ev)
+	}
+	close(done)
+}
+
+// Subscribe registers handler to receive all events. How and when handlers 
+// are called depends on EventDelivery. Either way, a slow handler slows down 
+// the upload. Call the returned function to unsubscribe.
+func (cc *CowClient) Subscribe(handler EventHandler) (unsubscribe func()) {
+	return cc.events.subscribe(handler)
+}
+
+// Events returns a channel that receives all events until ctx is done, at
+// which point the channel is closed. The channel is buffered. When the buffer
+// is full, BlockEvent and RetryEvent are dropped, while SessionEvent and
+// FileEvent wait for room in the buffer, holding up the upload.
+func (cc *CowClient) Events(ctx context.Context) <-chan Event {
+	ch := make(chan Event, eventBufferSize)
+	mutex := sync.Mutex{}
+	closed := false
+
+	unsubscribe := cc.events.subscribe(func(ev Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if closed {
+			return
+		}
+
+		switch ev.(type) {
+		case BlockEvent, RetryEvent:
+			select {
+			case ch <- ev:
+			default:
+			}
+		default:
+			select {
+			case ch <- ev:
+			case <-ctx.Done():
+			}
+		}
+	})
+
+	go func() {
+		<-ctx.Done()
+		unsubscribe()
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		closed = true
+		close(ch)
+	}()
+
+	return ch
+}
+
+// emitSession publishes a SessionEvent.
+func (cc *CowClient) emitSession(s *UploadSession, closed bool) {
+	if cc.events.active() {
+		cc.events.publish(SessionEvent{UploadSession: *s, Closed: closed})
+	}
+}
+
+// emitTransfer publishes ft as a FileEvent, BlockEvent or RetryEvent,
+// depending on its state.
+func (cc *CowClient) emitTransfer(ft *FileTransfer) {
+	switch ft.State {
+	case DoBlock, DoneBlock, FailBlock:
+		cc.events.publish(BlockEvent{*ft})
+	case RetryBlock:
+		cc.events.publish(RetryEvent{*ft})
+	default:
+		cc.events.publish(FileEvent{*ft})
+	}
+}
+
+// setHook replaces the subscription held in slot with handler. A nil handler
+// only removes the previous subscription.
+func (cc *CowClient) setHook(slot *func(), handler EventHandler) {
+	if *slot != nil {
+		(*slot)()
+		*slot = nil
+	}
+	if handler != nil {
+		*slot = cc.events.subscribe(handler)
+	}
+}
@@ -0,0 +1,121 @@
+package cowtransfer
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	cc := NewClient()
+
+	hooked := 0
+	cc.OnFileTransfer(func(ft *FileTransfer) { hooked++ })
+	// replacing a hook must not add a second one
+	cc.OnFileTransfer(func(ft *FileTransfer) { hooked++ })
+
+	a, b := 0, 0
+	unsubA := cc.Subscribe(func(ev Event) { a++ })
+	cc.Subscribe(func(ev Event) { b++ })
+
+	started := 0
+	cc.OnStart(func(s *UploadSession) { started++ })
+
+	cc.emitSession(&UploadSession{}, false)
+	cc.emitTransfer(&FileTransfer{State: DoBlock})
+	unsubA()
+	unsubA()
+	cc.emitTransfer(&FileTransfer{State: RetryBlock})
+
+	if a != 2 || b != 3 {
+		t.Errorf("expected 2 and 3 events, got %d and %d", a, b)
+	}
+	if hooked != 2 {
+		t.Errorf("expected 2 hook calls, got %d", hooked)
+	}
+	if started != 1 {
+		t.Errorf("expected 1 start hook call, got %d", started)
+	}
+
+	cc.OnFileTransfer(nil)
+	cc.emitTransfer(&FileTransfer{State: DoBlock})
+	if hooked != 2 {
+		t.Errorf("hook called after removal")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	cc := NewClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := cc.Events(ctx)
+
+	// block events beyond the buffer are dropped instead of blocking
+	for i := 0; i < eventBufferSize+10; i++ {
+		cc.emitTransfer(&FileTransfer{State: DoBlock, BlockNumber: int64(i + 1)})
+	}
+	if len(ch) != eventBufferSize {
+		t.Errorf("expected %d buffered events, got %d", eventBufferSize, len(ch))
+	}
+
+	ev := <-ch
+	if be, ok := ev.(BlockEvent); !ok || be.BlockNumber != 1 {
+		t.Errorf("unexpected first event: %#v", ev)
+	}
+
+	cancel()
+	count := 0
+	for range ch {
+		count++
+	}
+	if count != eventBufferSize-1 {
+		t.Errorf("expected %d remaining events, got %d", eventBufferSize-1, count)
+	}
+	if cc.events.active() {
+		t.Errorf("expected no subscribers after cancel")
+	}
+}
+
+func TestSerialDelivery(t *testing.T) {
+	cc := NewClient()
+	cc.EventDelivery = SerialDelivery
+
+	running := int32(0)
+	received := 0
+	lastDone := int64(0)
+	cc.Subscribe(func(ev Event) {
+		if atomic.AddInt32(&running, 1) != 1 {
+			t.Errorf("handler called concurrently")
+		}
+		defer atomic.AddInt32(&running, -1)
+
+		be := ev.(BlockEvent)
+		if be.DoneBlocks < lastDone {
+			t.Errorf("DoneBlocks went backwards: %d after %d", be.DoneBlocks, lastDone)
+		}
+		lastDone = be.DoneBlocks
+		received++
+	})
+
+	cc.events.startSerial()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				// workers report stale counters in random order
+				cc.emitTransfer(&FileTransfer{Path: "a", State: DoneBlock, DoneBlocks: int64(j*8 + worker)})
+			}
+		}(i)
+	}
+	wg.Wait()
+	cc.events.stopSerial()
+
+	if received != 800 {
+		t.Errorf("expected all 800 events delivered before stop returns, got %d", received)
+	}
+	if cc.events.queue != nil {
+		t.Errorf("expected direct delivery after stop")
+	}
+}
@@ -1,6 +1,7 @@
 package cowtransfer_test
 
 import (
+	"context"
 	"fmt"
 	"github.com/imacks/cowtransfer"
 )
@@ -40,3 +41,33 @@ func ExampleNewClient() {
 		fmt.Printf("%v\n", v)
 	}
 }
+
+func ExampleCowClient_Events() {
+	cc := cowtransfer.NewClient()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// any number of consumers can listen at the same time
+	go func() {
+		for ev := range cc.Events(ctx) {
+			switch e := ev.(type) {
+			case cowtransfer.SessionEvent:
+				fmt.Printf("session %s closed=%v\n", e.TransferGUID, e.Closed)
+			case cowtransfer.FileEvent:
+				fmt.Printf("%s: %s\n", e.Path, e.State)
+			}
+		}
+	}()
+
+	unsubscribe := cc.Subscribe(func(ev cowtransfer.Event) {
+		if e, ok := ev.(cowtransfer.RetryEvent); ok {
+			fmt.Printf("retrying block %d: %v\n", e.BlockNumber, e.Error)
+		}
+	})
+	defer unsubscribe()
+
+	if _, err := cc.Upload("./testdata/dummy.txt"); err != nil {
+		panic(err)
+	}
+}
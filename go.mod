@@ -1,3 +1,5 @@
 module github.com/imacks/cowtransfer
 
 go 1.16
+
+require golang.org/x/term v0.0.0-20210220032956-6a3ed077a48d
@@ -0,0 +1,533 @@
+package cowtransfer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	harVersion = "1.2"
+	// defaultHARBodySize is the default number of body bytes recorded.
+	defaultHARBodySize = 65536
+)
+
+// harFile is the root of a HAR 1.2 document.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	// Error is set if no response was received. Custom HAR fields start with
+	// an underscore.
+	Error string `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string         `json:"mimeType"`
+	Text     string         `json:"text"`
+	Params   []harNameValue `json:"params,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HARRecorder is a http.RoundTripper that records every request and response
+// in HAR 1.2 format. Bodies are truncated, binary request bodies like
+// uploaded blocks are not recorded at all, and upload tokens, cookies and
+// passwords are redacted, so that a recording can be attached to bug reports.
+// Set it as CowClient.Transport. It is safe for concurrent use.
+type HARRecorder struct {
+	// Transport sends the requests. Defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// MaxBodySize is the number of body bytes recorded. Defaults to 64kb.
+	MaxBodySize int
+	mutex       sync.Mutex
+	entries     []harEntry
+}
+
+// NewHARRecorder creates a recorder that sends requests with transport. A
+// nil transport uses http.DefaultTransport.
+func NewHARRecorder(transport http.RoundTripper) *HARRecorder {
+	return &HARRecorder{
+		Transport:   transport,
+		MaxBodySize: defaultHARBodySize,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (hr *HARRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := hr.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	reqBody, reqSize, err := hr.readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := harEntry{
+		StartedDateTime: time.Now(),
+		Request:         hr.harRequest(req, reqBody, reqSize),
+	}
+
+	start := time.Now()
+	response, err := transport.RoundTrip(req)
+	wait := time.Since(start)
+
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Time = durationMillis(wait)
+		entry.Timings = harTimings{Wait: entry.Time}
+		_ = hr.add(entry)
+		return nil, err
+	}
+
+	entry.Response = hr.harResponse(response)
+	entry.Time = durationMillis(wait)
+	entry.Timings = harTimings{Wait: entry.Time}
+	index := hr.add(entry)
+
+	// the body is passed through as it is read, because downloads can be
+	// too large to buffer. The entry is completed when the body is closed.
+	response.Body = &harBody{
+		ReadCloser: response.Body,
+		recorder:   hr,
+		index:      index,
+		start:      start,
+		wait:       wait,
+		text:       entry.Response.Content.MimeType == "" || isTextMimeType(entry.Response.Content.MimeType),
+	}
+	return response, nil
+}
+
+// readRequestBody returns the start of the request body to record, and the
+// body size. Only text and form bodies are read, up to MaxBodySize, and
+// req.Body is replaced so that the whole body is still sent. Other bodies,
+// like uploaded blocks, are sent unread and only their size is recorded.
+func (hr *HARRecorder) readRequestBody(req *http.Request) ([]byte, int64, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, 0, nil
+	}
+	mimeType := req.Header.Get("Content-Type")
+	if !isTextMimeType(mimeType) && !isFormMimeType(mimeType) {
+		return nil, req.ContentLength, nil
+	}
+
+	body := req.Body
+	head, err := io.ReadAll(io.LimitReader(body, int64(hr.maxBodySize())+1))
+	if err != nil {
+		_ = body.Close()
+		return nil, 0, err
+	}
+	if len(head) <= hr.maxBodySize() {
+		_ = body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(head))
+		return head, int64(len(head)), nil
+	}
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(head), body), body}
+	return head, req.ContentLength, nil
+}
+
+// harBody records a response body while it is read.
+type harBody struct {
+	io.ReadCloser
+	recorder *HARRecorder
+	index    int
+	start    time.Time
+	wait     time.Duration
+	text     bool
+	size     int64
+	buffer   bytes.Buffer
+	err      error
+	once     sync.Once
+}
+
+func (hb *harBody) Read(p []byte) (int, error) {
+	n, err := hb.ReadCloser.Read(p)
+	hb.size += int64(n)
+	if hb.text {
+		if room := hb.recorder.maxBodySize() + 1 - hb.buffer.Len(); room > 0 {
+			if room > n {
+				room = n
+			}
+			hb.buffer.Write(p[:room])
+		}
+	}
+	if err != nil && err != io.EOF {
+		hb.err = err
+	}
+	return n, err
+}
+
+func (hb *harBody) Close() error {
+	err := hb.ReadCloser.Close()
+	hb.once.Do(hb.finish)
+	return err
+}
+
+func (hb *harBody) finish() {
+	receive := time.Since(hb.start) - hb.wait
+
+	hr := hb.recorder
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	entry := &hr.entries[hb.index]
+	entry.Time = durationMillis(hb.wait + receive)
+	entry.Timings.Receive = durationMillis(receive)
+	entry.Response.BodySize = hb.size
+	entry.Response.Content.Size = hb.size
+	if hb.text {
+		text := secretJSONRegex.ReplaceAllString(hb.buffer.String(), `$1"`+redacted+`"`)
+		entry.Response.Content.Text = hr.truncate(text)
+		if int64(len(entry.Response.Content.Text)) < hb.size {
+			entry.Response.Content.Comment = "truncated"
+		}
+	}
+	if hb.err != nil {
+		entry.Error = hb.err.Error()
+	}
+}
+
+// add appends entry and returns its index.
+func (hr *HARRecorder) add(entry harEntry) int {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	hr.entries = append(hr.entries, entry)
+	return len(hr.entries) - 1
+}
+
+func (hr *HARRecorder) harRequest(req *http.Request, body []byte, size int64) harRequest {
+	u := redactURL(req.URL)
+	result := harRequest{
+		Method:      req.Method,
+		URL:         u,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(req.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    size,
+	}
+	if parsed, err := url.Parse(u); err == nil {
+		for k, values := range parsed.Query() {
+			for _, v := range values {
+				result.QueryString = append(result.QueryString, harNameValue{k, v})
+			}
+		}
+	}
+	for _, v := range req.Cookies() {
+		result.Cookies = append(result.Cookies, harNameValue{v.Name, redacted})
+	}
+
+	if size != 0 {
+		mimeType := req.Header.Get("Content-Type")
+		result.PostData = &harPostData{MimeType: mimeType}
+		if params, ok := harFormParams(mimeType, body); ok {
+			result.PostData.Params = params
+		} else if isTextMimeType(mimeType) {
+			result.PostData.Text = hr.truncate(secretJSONRegex.ReplaceAllString(string(body), `$1"`+redacted+`"`))
+		}
+	}
+	return result
+}
+
+func (hr *HARRecorder) harResponse(response *http.Response) harResponse {
+	result := harResponse{
+		Status:      response.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(response.Status, fmt.Sprint(response.StatusCode))),
+		HTTPVersion: response.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(response.Header),
+		Content: harContent{
+			MimeType: response.Header.Get("Content-Type"),
+		},
+		RedirectURL: response.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+	for _, v := range response.Cookies() {
+		result.Cookies = append(result.Cookies, harNameValue{v.Name, redacted})
+	}
+	return result
+}
+
+func (hr *HARRecorder) maxBodySize() int {
+	if hr.MaxBodySize <= 0 {
+		return defaultHARBodySize
+	}
+	return hr.MaxBodySize
+}
+
+func (hr *HARRecorder) truncate(s string) string {
+	if max := hr.maxBodySize(); len(s) > max {
+		return s[:max]
+	}
+	return s
+}
+
+// WriteHAR writes everything recorded so far to w as a HAR document.
+func (hr *HARRecorder) WriteHAR(w io.Writer) error {
+	hr.mutex.Lock()
+	entries := make([]harEntry, len(hr.entries))
+	copy(entries, hr.entries)
+	hr.mutex.Unlock()
+
+	doc := harFile{Log: harLog{
+		Version: harVersion,
+		Creator: harCreator{Name: "github.com/imacks/cowtransfer", Version: harVersion},
+		Entries: entries,
+	}}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// Save writes everything recorded so far to a HAR file.
+func (hr *HARRecorder) Save(path string) error {
+	buffer := &bytes.Buffer{}
+	if err := hr.WriteHAR(buffer); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buffer.Bytes(), 0600)
+}
+
+// HARReplayer is a http.RoundTripper that answers requests from a HAR
+// document instead of the network. Each request is matched by method and URL
+// to the first recorded entry that has not been used yet, so repeated
+// requests get their responses in recorded order. Secrets in the request URL
+// are redacted before matching, the same way HARRecorder does. Set it as
+// CowClient.Transport. It is safe for concurrent use.
+type HARReplayer struct {
+	mutex   sync.Mutex
+	entries []harEntry
+	used    []bool
+}
+
+// NewHARReplayer reads a HAR document from r.
+func NewHARReplayer(r io.Reader) (*HARReplayer, error) {
+	doc := harFile{}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("cannot parse HAR: %w", err)
+	}
+	return &HARReplayer{
+		entries: doc.Log.Entries,
+		used:    make([]bool, len(doc.Log.Entries)),
+	}, nil
+}
+
+// LoadHAR reads a HAR file for replay.
+func LoadHAR(path string) (*HARReplayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewHARReplayer(f)
+}
+
+// RoundTrip implements http.RoundTripper. Returns ErrNotRecorded if there is
+// no matching entry left.
+func (hp *HARReplayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		_ = req.Body.Close()
+	}
+
+	u := redactURL(req.URL)
+	entry, ok := hp.next(req.Method, u)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %s", ErrNotRecorded, req.Method, u)
+	}
+	if entry.Error != "" {
+		return nil, errors.New(entry.Error)
+	}
+
+	header := http.Header{}
+	for _, v := range entry.Response.Headers {
+		header.Add(v.Name, v.Value)
+	}
+	body := []byte(entry.Response.Content.Text)
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Response.Status, entry.Response.StatusText),
+		StatusCode:    entry.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func (hp *HARReplayer) next(method, u string) (harEntry, bool) {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	for i, v := range hp.entries {
+		if hp.used[i] || v.Request.Method != method || v.Request.URL != u {
+			continue
+		}
+		hp.used[i] = true
+		return v, true
+	}
+	return harEntry{}, false
+}
+
+// Remaining returns the number of recorded entries not replayed yet.
+func (hp *HARReplayer) Remaining() int {
+	hp.mutex.Lock()
+	defer hp.mutex.Unlock()
+
+	count := 0
+	for _, v := range hp.used {
+		if !v {
+			count++
+		}
+	}
+	return count
+}
+
+// harHeaders converts headers with secrets redacted.
+func harHeaders(h http.Header) []harNameValue {
+	result := []harNameValue{}
+	for k, values := range h {
+		for _, v := range values {
+			switch http.CanonicalHeaderKey(k) {
+			case "Authorization":
+				v = redactAuthorization(v)
+			case "Cookie":
+				v = redactCookies(v)
+			case "Set-Cookie":
+				v = redactSetCookies([]string{v})
+			}
+			result = append(result, harNameValue{k, v})
+		}
+	}
+	return result
+}
+
+// harFormParams returns the fields of a multipart form body with secrets
+// redacted.
+func harFormParams(mimeType string, body []byte) ([]harNameValue, bool) {
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, false
+	}
+
+	result := []harNameValue{}
+	reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return nil, false
+		}
+
+		v := string(value)
+		if isSecretParam(part.FormName()) && v != "" {
+			v = redacted
+		}
+		result = append(result, harNameValue{part.FormName(), v})
+	}
+	return result, true
+}
+
+func isFormMimeType(mimeType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	return mediaType == "multipart/form-data"
+}
+
+func isTextMimeType(mimeType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml") ||
+		mediaType == "application/x-www-form-urlencoded"
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,189 @@
+package cowtransfer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestHARRecordAndReplay(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, strings.Repeat("x", 100))
+		default:
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "servercookie"})
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"uptoken":"secrettoken","path":%q}`, r.URL.Path)
+		}
+	}))
+
+	recorder := NewHARRecorder(nil)
+	cc := NewClient()
+	cc.APIURL = ts.URL
+	cc.Token = "login=clientcookie"
+	cc.Transport = recorder
+
+	if _, err := cc.newMultipartFormRequest(ts.URL+"/transfer/v2/bindpasscode", map[string]string{"passcode": "secretpass", "transferguid": "g1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cc.newFileUploadRequest(ts.URL+"/upload", strings.NewReader("data"), "application/octet-stream", "secretuptoken", "PUT"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	detailsURL := ts.URL + "/transfer/transferdetail?url=abc&passcode=secretquery"
+	recorded, err := cc.newFileDownloadRequest(detailsURL, "abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := cc.Download(FileInfo{FileName: "f", URL: ts.URL + "/file"}, &bytes.Buffer{}); err != nil || n != 100 {
+		t.Fatalf("download: got %d, %v", n, err)
+	}
+	ts.Close()
+
+	har := &bytes.Buffer{}
+	if err := recorder.WriteHAR(har); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, secret := range []string{"secrettoken", "servercookie", "clientcookie", "secretpass", "secretuptoken", "secretquery", "xxxx"} {
+		if strings.Contains(har.String(), secret) {
+			t.Errorf("HAR leaks %s", secret)
+		}
+	}
+	for _, expect := range []string{`"version": "1.2"`, `"transferguid"`, `"method": "PUT"`, `"size": 100`} {
+		if !strings.Contains(har.String(), expect) {
+			t.Errorf("HAR is missing %s", expect)
+		}
+	}
+
+	replayer, err := NewHARReplayer(har)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replayer.Remaining() != 4 {
+		t.Fatalf("expected 4 entries, got %d", replayer.Remaining())
+	}
+	cc = NewClient()
+	cc.APIURL = ts.URL
+	cc.Transport = replayer
+
+	// the password differs, but is redacted before matching
+	replayed, err := cc.newFileDownloadRequest(ts.URL+"/transfer/transferdetail?url=abc&passcode=other", "abc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := strings.Replace(string(recorded), "secrettoken", redacted, 1)
+	if string(replayed) != expect {
+		t.Errorf("expect %s, got %s", expect, replayed)
+	}
+	if replayer.Remaining() != 3 {
+		t.Errorf("expected 3 entries left, got %d", replayer.Remaining())
+	}
+
+	_, err = cc.newFileDownloadRequest(detailsURL, "abc")
+	if !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("expect ErrNotRecorded, got %v", err)
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// blockBody is a request body that can be told apart from a replacement.
+type blockBody struct {
+	*strings.Reader
+}
+
+func (b *blockBody) Close() error {
+	return nil
+}
+
+func TestHARRecorderRequestBodies(t *testing.T) {
+	mutex := sync.Mutex{}
+	received := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		received[r.URL.Path] = len(body)
+		mutex.Unlock()
+	}))
+	defer ts.Close()
+
+	block := &blockBody{strings.NewReader(strings.Repeat("b", 100))}
+	recorder := NewHARRecorder(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/block" && req.Body != block {
+			t.Errorf("binary body was read by the recorder")
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	}))
+	recorder.MaxBodySize = 10
+
+	req, _ := http.NewRequest("PUT", ts.URL+"/block", block)
+	req.ContentLength = 100
+	req.Header.Set("Content-Type", "application/octet-stream")
+	requests := []*http.Request{req}
+	req, _ = http.NewRequest("POST", ts.URL+"/text", strings.NewReader(strings.Repeat("t", 100)))
+	req.Header.Set("Content-Type", "text/plain")
+	requests = append(requests, req)
+	for _, v := range requests {
+		response, err := recorder.RoundTrip(v)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		response.Body.Close()
+	}
+
+	mutex.Lock()
+	if received["/block"] != 100 || received["/text"] != 100 {
+		t.Errorf("server got %v", received)
+	}
+	mutex.Unlock()
+	entries := recorder.entries
+	if entries[0].Request.BodySize != 100 || entries[0].Request.PostData.Text != "" {
+		t.Errorf("unexpected block entry: %+v", entries[0].Request)
+	}
+	if entries[1].Request.BodySize != 100 || entries[1].Request.PostData.Text != strings.Repeat("t", 10) {
+		t.Errorf("unexpected text entry: %+v", entries[1].Request)
+	}
+}
+
+func TestHARRecordsUploadJSON(t *testing.T) {
+	fake := newFakeUploadServer(t)
+	defer fake.Close()
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := NewHARRecorder(nil)
+	cc := NewClient()
+	cc.APIURL = fake.URL
+	cc.OSSURL = fake.URL
+	cc.VerifyHash = false
+	cc.Transport = recorder
+	if _, err := cc.UploadWithResult(nil, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	har := &bytes.Buffer{}
+	if err := recorder.WriteHAR(har); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the part list sent to merge can be checked offline
+	for _, expect := range []string{`\"parts\":[{\"etag\":\"e\",\"partNumber\":1}]`, `\"fname\":\"data.bin\"`, `\"storagePrefix\":\"p\"`} {
+		if !strings.Contains(har.String(), expect) {
+			t.Errorf("HAR is missing %s", expect)
+		}
+	}
+}
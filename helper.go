@@ -23,7 +23,7 @@ func listFilesInPath(fspath ...string) ([]string, int64, error) {
 			if os.IsNotExist(err) {
 				return nil, -1, fmt.Errorf("path not found: %s", v)
 			} else {
-				return nil, -1, fmt.Errorf("cannot stat %s: %v", v, err)
+				return nil, -1, fmt.Errorf("cannot stat %s: %w", v, err)
 			}
 		}
 
@@ -57,7 +57,7 @@ func listFilesInPath(fspath ...string) ([]string, int64, error) {
 		})
 
 		if err != nil {
-			return nil, -1, fmt.Errorf("cannot recursively stat %s: %v", v, err)
+			return nil, -1, fmt.Errorf("cannot recursively stat %s: %w", v, err)
 		}
 	}
 
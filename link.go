@@ -0,0 +1,117 @@
+package cowtransfer
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// LinkKind is the format a download link was given in.
+type LinkKind int
+const (
+	// ShortLink is a link like https://cowtransfer.com/s/<id>.
+	ShortLink LinkKind = iota
+	// QueryLink is a link that passes the ID as a query string parameter,
+	// like https://cowtransfer.com/transfer/transferdetail?url=<id>.
+	QueryLink
+	// BareID is just the ID without any URL around it.
+	BareID
+)
+
+func (lk LinkKind) String() string {
+	switch lk {
+	case ShortLink:
+		return "ShortLink"
+	case QueryLink:
+		return "QueryLink"
+	case BareID:
+		return "BareID"
+	default:
+		return "undefined"
+	}
+}
+
+// Link is a parsed download link.
+type Link struct {
+	// Host is the hostname in the link. It is empty for BareID.
+	Host string `json:"host"`
+	// ID is the unique ID of the transfer.
+	ID string `json:"id"`
+	// Kind is the format of the link.
+	Kind LinkKind `json:"kind"`
+}
+
+// String returns the link as a short link. The default host is used for
+// BareID links.
+func (l Link) String() string {
+	host := l.Host
+	if host == "" {
+		host = strings.TrimPrefix(defaultAPIURL, "https://")
+	}
+	return fmt.Sprintf("https://%s/s/%s", host, l.ID)
+}
+
+var (
+	linkIDRegex = regexp.MustCompile("^[0-9a-f]{14}$")
+	linkDomains = []string{"cowtransfer.com", "cowtransfer.cn"}
+	// query string parameters that may hold the ID
+	linkQueryKeys = []string{"url", "uniqueurl", "id"}
+)
+
+// ParseLink parses a download link. It understands short links and query
+// string variants on cowtransfer.com and cowtransfer.cn (including their
+// subdomains), as well as a bare ID. Errors returned wrap ErrDownloadURL.
+func ParseLink(s string) (Link, error) {
+	s = strings.TrimSpace(s)
+	if linkIDRegex.MatchString(s) {
+		return Link{ID: s, Kind: BareID}, nil
+	}
+
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return Link{}, fmt.Errorf("%w: %v", ErrDownloadURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Link{}, fmt.Errorf("%w: unsupported scheme %s", ErrDownloadURL, u.Scheme)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if !isLinkHost(host) {
+		return Link{}, fmt.Errorf("%w: unsupported host %s", ErrDownloadURL, host)
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(segments) == 2 && segments[0] == "s" {
+		if !linkIDRegex.MatchString(segments[1]) {
+			return Link{}, fmt.Errorf("%w: invalid ID %s", ErrDownloadURL, segments[1])
+		}
+		return Link{Host: host, ID: segments[1], Kind: ShortLink}, nil
+	}
+
+	query := u.Query()
+	for _, k := range linkQueryKeys {
+		v := query.Get(k)
+		if v == "" {
+			continue
+		}
+		if !linkIDRegex.MatchString(v) {
+			return Link{}, fmt.Errorf("%w: invalid ID %s", ErrDownloadURL, v)
+		}
+		return Link{Host: host, ID: v, Kind: QueryLink}, nil
+	}
+
+	return Link{}, fmt.Errorf("%w: no ID in %s", ErrDownloadURL, s)
+}
+
+func isLinkHost(host string) bool {
+	for _, v := range linkDomains {
+		if host == v || strings.HasSuffix(host, "."+v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package cowtransfer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseLink(t *testing.T) {
+	cases := []struct {
+		in   string
+		link Link
+		ok   bool
+	}{
+		{"https://cowtransfer.com/s/abab0000123456", Link{"cowtransfer.com", "abab0000123456", ShortLink}, true},
+		{"http://cowtransfer.cn/s/abab0000123456/", Link{"cowtransfer.cn", "abab0000123456", ShortLink}, true},
+		{"cowtransfer.com/s/abab0000123456", Link{"cowtransfer.com", "abab0000123456", ShortLink}, true},
+		{"https://c-abc.cowtransfer.com/s/abab0000123456?utm=x", Link{"c-abc.cowtransfer.com", "abab0000123456", ShortLink}, true},
+		{"https://cowtransfer.com/transfer/transferdetail?url=abab0000123456", Link{"cowtransfer.com", "abab0000123456", QueryLink}, true},
+		{" abab0000123456 ", Link{"", "abab0000123456", BareID}, true},
+		{"https://example.com/s/abab0000123456", Link{}, false},
+		{"https://notcowtransfer.com/s/abab0000123456", Link{}, false},
+		{"https://cowtransfer.com/s/abab", Link{}, false},
+		{"https://cowtransfer.com/abab0000123456abab0000123456", Link{}, false},
+		{"ftp://cowtransfer.com/s/abab0000123456", Link{}, false},
+		{"abab00001234567", Link{}, false},
+	}
+
+	for _, c := range cases {
+		link, err := ParseLink(c.in)
+		if !c.ok {
+			if !errors.Is(err, ErrDownloadURL) {
+				t.Errorf("%q: expected ErrDownloadURL, got %v", c.in, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.in, err)
+			continue
+		}
+		if link != c.link {
+			t.Errorf("%q: expected %+v, got %+v", c.in, c.link, link)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package cowtransfer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTraceBody is the number of response body bytes included in a trace.
+	maxTraceBody = 512
+	redacted     = "[REDACTED]"
+)
+
+// Logger is a leveled logger. keyvals are alternating keys and values that
+// add context to msg, like "url", "https://...", "status", 200.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (ll LogLevel) String() string {
+	switch ll {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return "undefined"
+	}
+}
+
+// textLogger writes one line per message in a key=value format.
+type textLogger struct {
+	mutex sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+// NewTextLogger creates a Logger that writes messages at level and above to
+// w, one line per message:
+//
+//   2006-01-02T15:04:05Z07:00 level=info msg="session open" guid=abc
+func NewTextLogger(w io.Writer, level LogLevel) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+func (tl *textLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < tl.level {
+		return
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%s level=%s msg=%q", time.Now().Format(time.RFC3339), level, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var val interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+		s := fmt.Sprint(val)
+		if s == "" || strings.ContainsAny(s, " \"=\t\r\n") {
+			s = fmt.Sprintf("%q", s)
+		}
+		fmt.Fprintf(sb, " %v=%s", keyvals[i], s)
+	}
+	sb.WriteString("\n")
+
+	tl.mutex.Lock()
+	defer tl.mutex.Unlock()
+	_, _ = io.WriteString(tl.w, sb.String())
+}
+
+func (tl *textLogger) Debug(msg string, keyvals ...interface{}) { tl.log(LevelDebug, msg, keyvals) }
+func (tl *textLogger) Info(msg string, keyvals ...interface{})  { tl.log(LevelInfo, msg, keyvals) }
+func (tl *textLogger) Warn(msg string, keyvals ...interface{})  { tl.log(LevelWarn, msg, keyvals) }
+func (tl *textLogger) Error(msg string, keyvals ...interface{}) { tl.log(LevelError, msg, keyvals) }
+
+// nopLogger discards everything.
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (nopLogger) Info(msg string, keyvals ...interface{})  {}
+func (nopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+// logger returns Logger, or a logger that discards everything if not set.
+func (cc *CowClient) logger() Logger {
+	if cc.Logger == nil {
+		return nopLogger{}
+	}
+	return cc.Logger
+}
+
+var (
+	// JSON string values of secrets in response bodies
+	secretJSONRegex = regexp.MustCompile(`(?i)("(?:uptoken|token|passcode|password)"\s*:\s*)"[^"]*"`)
+	// form fields and query string parameters that hold secrets
+	secretParams = []string{"passcode", "password", "token", "uptoken"}
+)
+
+// doRequest sends req and returns the response with its body fully read. In
+// Debug mode, the exchange is traced with secrets redacted. params are the
+// form fields sent in the request body, if any, for the trace.
+func (cc *CowClient) doRequest(client *http.Client, req *http.Request, params map[string]string) (*http.Response, []byte, error) {
+	start := time.Now()
+	response, err := client.Do(req)
+	var bodyBytes []byte
+	if err == nil {
+		bodyBytes, err = io.ReadAll(response.Body)
+		_ = response.Body.Close()
+	}
+
+	if cc.Debug && cc.Logger != nil {
+		keyvals := []interface{}{
+			"method", req.Method,
+			"url", redactURL(req.URL),
+			"duration", time.Since(start).Round(time.Millisecond),
+		}
+		if len(params) > 0 {
+			keyvals = append(keyvals, "form", redactParams(params))
+		} else if req.ContentLength > 0 {
+			keyvals = append(keyvals, "request_size", req.ContentLength)
+		}
+		if h := req.Header.Get("Authorization"); h != "" {
+			keyvals = append(keyvals, "authorization", redactAuthorization(h))
+		}
+		if h := req.Header.Get("Cookie"); h != "" {
+			keyvals = append(keyvals, "cookie", redactCookies(h))
+		}
+		if response != nil {
+			keyvals = append(keyvals, "status", response.StatusCode)
+			if h := response.Header.Values("Set-Cookie"); len(h) > 0 {
+				keyvals = append(keyvals, "set_cookie", redactSetCookies(h))
+			}
+			keyvals = append(keyvals, "body", redactBody(bodyBytes))
+		}
+		if err != nil {
+			keyvals = append(keyvals, "error", err)
+		}
+		cc.Logger.Debug("http request", keyvals...)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, bodyBytes, nil
+}
+
+func isSecretParam(key string) bool {
+	for _, v := range secretParams {
+		if strings.EqualFold(key, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	changed := false
+	for k := range query {
+		if isSecretParam(k) && query.Get(k) != "" {
+			query.Set(k, redacted)
+			changed = true
+		}
+	}
+	if !changed {
+		return u.String()
+	}
+
+	clean := *u
+	clean.RawQuery = query.Encode()
+	return clean.String()
+}
+
+func redactParams(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := params[k]
+		if isSecretParam(k) && v != "" {
+			v = redacted
+		}
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, "&")
+}
+
+func redactAuthorization(h string) string {
+	if i := strings.Index(h, " "); i > 0 {
+		return h[:i] + " " + redacted
+	}
+	return redacted
+}
+
+// redactCookies keeps cookie names only.
+func redactCookies(h string) string {
+	names := []string{}
+	for _, v := range strings.Split(h, ";") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if i := strings.Index(v, "="); i >= 0 {
+			v = v[:i]
+		}
+		names = append(names, v+"="+redacted)
+	}
+	return strings.Join(names, "; ")
+}
+
+func redactSetCookies(h []string) string {
+	names := []string{}
+	for _, v := range h {
+		names = append(names, redactCookies(strings.SplitN(v, ";", 2)[0]))
+	}
+	return strings.Join(names, "; ")
+}
+
+func redactBody(body []byte) string {
+	s := secretJSONRegex.ReplaceAllString(string(body), `$1"`+redacted+`"`)
+	if len(s) > maxTraceBody {
+		s = s[:maxTraceBody] + fmt.Sprintf("...(%d bytes)", len(body))
+	}
+	return s
+}
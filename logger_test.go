@@ -0,0 +1,58 @@
+package cowtransfer
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugTraceRedaction(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "servercookie"})
+		fmt.Fprint(w, `{"uptoken":"secrettoken","transferguid":"g1"}`)
+	}))
+	defer ts.Close()
+
+	out := &bytes.Buffer{}
+	cc := NewClient()
+	cc.APIURL = ts.URL
+	cc.Token = "login=clientcookie"
+	cc.Logger = NewTextLogger(out, LevelDebug)
+	cc.Debug = true
+
+	if _, err := cc.newMultipartFormRequest(ts.URL+"/transfer/v2/bindpasscode", map[string]string{"passcode": "secretpass", "transferguid": "g1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cc.newFileUploadRequest(ts.URL+"/upload", strings.NewReader("data"), "application/octet-stream", "secretuptoken", "PUT"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := cc.newFileDownloadRequest(ts.URL+"/transfer/transferdetail?url=abc&passcode=secretquery", "abc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	trace := out.String()
+	if strings.Count(trace, "msg=\"http request\"") != 3 {
+		t.Errorf("expected 3 traced requests, got:\n%s", trace)
+	}
+	for _, secret := range []string{"secrettoken", "servercookie", "clientcookie", "secretpass", "secretuptoken", "secretquery"} {
+		if strings.Contains(trace, secret) {
+			t.Errorf("trace leaks %s:\n%s", secret, trace)
+		}
+	}
+	for _, expect := range []string{"method=POST", "method=PUT", "method=GET", "status=200", "transferguid", "login=[REDACTED]"} {
+		if !strings.Contains(trace, expect) {
+			t.Errorf("trace is missing %s:\n%s", expect, trace)
+		}
+	}
+}
+
+func TestRedactBodyTruncates(t *testing.T) {
+	body := []byte(strings.Repeat("a", maxTraceBody*2))
+	got := redactBody(body)
+	if !strings.HasPrefix(got, strings.Repeat("a", maxTraceBody)+"...") {
+		t.Errorf("body not truncated: %s", got)
+	}
+}
@@ -0,0 +1,128 @@
+package cowtransfer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Manifest lists the sizes and SHA-256 hashes of uploaded files, so that
+// downloads can be checked later. Files are named as in the transfer.
+type Manifest struct {
+	Files []ManifestFile `json:"files"`
+	mutex sync.Mutex
+}
+
+// ManifestFile holds the hashes of a file. Hashes are hex encoded, and are
+// empty when not known.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	// PieceLength is the size of every piece except the last, which is the
+	// upload block size.
+	PieceLength int64    `json:"piece_length,omitempty"`
+	Pieces      []string `json:"pieces,omitempty"`
+}
+
+// add appends a file. It is safe for concurrent use.
+func (m *Manifest) add(mf ManifestFile) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.Files = append(m.Files, mf)
+}
+
+// File returns the file named name, or nil if not found.
+func (m *Manifest) File(name string) *ManifestFile {
+	if m == nil {
+		return nil
+	}
+	for i, v := range m.Files {
+		if v.Name == name {
+			return &m.Files[i]
+		}
+	}
+	return nil
+}
+
+// Write writes the manifest to w as JSON.
+func (m *Manifest) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// Save writes the manifest to a file as JSON.
+func (m *Manifest) Save(path string) error {
+	buffer := &bytes.Buffer{}
+	if err := m.Write(buffer); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buffer.Bytes(), 0644)
+}
+
+// ReadManifest reads a manifest written by Manifest.Write.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest: %w", err)
+	}
+	return m, nil
+}
+
+// LoadManifest reads a manifest file written by Manifest.Save.
+func LoadManifest(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadManifest(f)
+}
+
+// HashFile computes the manifest entry of a local file, with pieces of
+// pieceLength bytes. Use it for files uploaded without RecordHashes.
+func HashFile(path string, pieceLength int) (ManifestFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ManifestFile{}, err
+	}
+	defer f.Close()
+
+	mf := ManifestFile{
+		Name:        filepath.Base(path),
+		PieceLength: int64(pieceLength),
+		Pieces:      []string{},
+	}
+	fileHash := sha256.New()
+	buffer := make([]byte, pieceLength)
+	for {
+		nr, err := io.ReadFull(f, buffer)
+		if nr > 0 {
+			mf.Size += int64(nr)
+			fileHash.Write(buffer[:nr])
+			mf.Pieces = append(mf.Pieces, sha256Hex(buffer[:nr]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return ManifestFile{}, err
+		}
+	}
+	mf.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+	return mf, nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
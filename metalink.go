@@ -0,0 +1,93 @@
+package cowtransfer
+
+import (
+	"encoding/xml"
+	"io"
+	"path/filepath"
+	"time"
+)
+
+const metalinkNamespace = "urn:ietf:params:xml:ns:metalink"
+
+// metalink is a Metalink 4 document. See RFC 5854.
+type metalink struct {
+	XMLName   xml.Name       `xml:"metalink"`
+	Namespace string         `xml:"xmlns,attr"`
+	Generator string         `xml:"generator"`
+	Published string         `xml:"published"`
+	Files     []metalinkFile `xml:"file"`
+}
+
+type metalinkFile struct {
+	Name   string          `xml:"name,attr"`
+	Size   int64           `xml:"size,omitempty"`
+	Hash   *metalinkHash   `xml:"hash,omitempty"`
+	Pieces *metalinkPieces `xml:"pieces,omitempty"`
+	URLs   []string        `xml:"url"`
+}
+
+type metalinkHash struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkPieces struct {
+	Length int64    `xml:"length,attr"`
+	Type   string   `xml:"type,attr"`
+	Hashes []string `xml:"hash"`
+}
+
+// WriteMetalink writes files as a Metalink 4 (.meta4) document to w. Files
+// without a download URL are left out. Sizes and hashes are taken from the
+// file of the same name in manifest, which may be nil. Files that are not in
+// manifest get the size reported by Files, which the server rounds to the
+// kilobyte, and no hashes. Names made by RelativeName keep their
+// directories.
+func WriteMetalink(w io.Writer, files []FileInfo, manifest *Manifest) error {
+	doc := metalink{
+		Namespace: metalinkNamespace,
+		Generator: "github.com/imacks/cowtransfer",
+		Published: time.Now().UTC().Format(time.RFC3339),
+		Files:     []metalinkFile{},
+	}
+
+	for _, v := range files {
+		if v.Error != nil || v.URL == "" {
+			continue
+		}
+		name := filepath.Base(v.FileName)
+		if local, err := v.LocalPath(); err == nil {
+			name = filepath.ToSlash(local)
+		}
+		mlf := metalinkFile{
+			Name: name,
+			Size: v.Size,
+			URLs: []string{v.URL},
+		}
+		if mf := manifest.File(v.FileName); mf != nil {
+			mlf.Size = mf.Size
+			if mf.SHA256 != "" {
+				mlf.Hash = &metalinkHash{Type: "sha-256", Value: mf.SHA256}
+			}
+			if len(mf.Pieces) > 0 && mf.PieceLength > 0 {
+				mlf.Pieces = &metalinkPieces{
+					Length: mf.PieceLength,
+					Type:   "sha-256",
+					Hashes: mf.Pieces,
+				}
+			}
+		}
+		doc.Files = append(doc.Files, mlf)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,107 @@
+package cowtransfer
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordHashes(t *testing.T) {
+	ts := newFakeUploadServer(t)
+	defer ts.Close()
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	data := make([]byte, minBlockSize*2+123)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expect, err := HashFile(path, minBlockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expect.Pieces) != 3 || expect.Size != int64(len(data)) {
+		t.Fatalf("unexpected HashFile result: %d pieces, size %d", len(expect.Pieces), expect.Size)
+	}
+
+	for _, threads := range []int{1, 3} {
+		cc := NewClient()
+		cc.APIURL = ts.URL
+		cc.OSSURL = ts.URL
+		cc.VerifyHash = false
+		cc.BlockSize = minBlockSize
+		cc.MaxPushBlocks = threads
+		cc.RecordHashes = true
+
+		var manifest *Manifest
+		cc.OnStop(func(s *UploadSession) {
+			manifest = s.Manifest
+		})
+		if _, err := cc.Upload(path); err != nil {
+			t.Fatalf("threads %d: unexpected error: %v", threads, err)
+		}
+
+		got := manifest.File("data.bin")
+		if got == nil {
+			t.Fatalf("threads %d: data.bin not in manifest", threads)
+		}
+		if !reflect.DeepEqual(got.Pieces, expect.Pieces) || got.PieceLength != expect.PieceLength || got.Size != expect.Size {
+			t.Errorf("threads %d: pieces do not match HashFile", threads)
+		}
+		if got.SHA256 != expect.SHA256 {
+			t.Errorf("threads %d: expect SHA-256 %s, got %s", threads, expect.SHA256, got.SHA256)
+		}
+	}
+}
+
+func TestWriteMetalink(t *testing.T) {
+	manifest := &Manifest{Files: []ManifestFile{{
+		Name:        "a.bin",
+		Size:        10,
+		SHA256:      "aaaa",
+		PieceLength: 4,
+		Pieces:      []string{"p1", "p2", "p3"},
+	}, {
+		Name:   "photos/d.bin",
+		Size:   20,
+		SHA256: "dddd",
+	}}}
+	files := []FileInfo{
+		{FileName: "a.bin", Size: 10, URL: "https://cdn.example.com/a?x=1&y=2"},
+		{FileName: "b.bin", Size: 1024, URL: "https://cdn.example.com/b"},
+		{FileName: "c.bin", Error: os.ErrNotExist},
+		{FileName: "photos/d.bin", Size: 20, URL: "https://cdn.example.com/d"},
+		{FileName: "../e.bin", URL: "https://cdn.example.com/e"},
+	}
+
+	out := &bytes.Buffer{}
+	if err := WriteMetalink(out, files, manifest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	doc := out.String()
+
+	for _, expect := range []string{
+		`<metalink xmlns="urn:ietf:params:xml:ns:metalink">`,
+		`<file name="a.bin">`,
+		`<size>10</size>`,
+		`<hash type="sha-256">aaaa</hash>`,
+		`<pieces length="4" type="sha-256">`,
+		`<hash>p3</hash>`,
+		`<url>https://cdn.example.com/a?x=1&amp;y=2</url>`,
+		`<file name="b.bin">`,
+		`<file name="photos/d.bin">`,
+		`<hash type="sha-256">dddd</hash>`,
+		`<file name="e.bin">`,
+	} {
+		if !strings.Contains(doc, expect) {
+			t.Errorf("metalink is missing %s:\n%s", expect, doc)
+		}
+	}
+	if !strings.Contains(doc, "<size>1024</size>") || strings.Contains(doc, "c.bin") {
+		t.Errorf("unexpected metalink:\n%s", doc)
+	}
+}
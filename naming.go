@@ -0,0 +1,186 @@
+package cowtransfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NameFunc returns the name of a file in the transfer. root is the path given
+// to Upload that path was found in, which is path itself for files given
+// directly.
+type NameFunc func(root, path string) (string, error)
+
+// CollisionPolicy decides what happens when two files get the same name in a
+// transfer. The first file always keeps its name.
+type CollisionPolicy int
+const (
+	// CollisionRename adds a suffix to the names of later files, like
+	// "report-2.pdf". This is the default, so that files with the same name
+	// can still be uploaded together.
+	CollisionRename CollisionPolicy = iota
+	// CollisionFail fails the upload before the transfer is created.
+	CollisionFail
+	// CollisionSkip leaves out later files.
+	CollisionSkip
+)
+
+func (cp CollisionPolicy) String() string {
+	switch cp {
+	case CollisionRename:
+		return "rename"
+	case CollisionFail:
+		return "fail"
+	case CollisionSkip:
+		return "skip"
+	default:
+		return "undefined"
+	}
+}
+
+// BaseName names a file by its base name. This is the default.
+func BaseName(root, path string) (string, error) {
+	return filepath.Base(path), nil
+}
+
+// RelativeName names a file by its path from the parent of root, with forward
+// slashes. The files of an uploaded directory keep the directory name and
+// layout, like "photos/2020/a.jpg". Files given directly get their base name.
+func RelativeName(root, path string) (string, error) {
+	rel, err := filepath.Rel(filepath.Dir(filepath.Clean(root)), path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// HashSuffixName adds the first 8 hex digits of the SHA-256 of the absolute
+// path to the base name, like "report-1a2b3c4d.pdf". Files with the same base
+// name in different directories get different names, and a file always gets
+// the same name.
+func HashSuffixName(root, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return addSuffix(filepath.Base(path), "-"+hex.EncodeToString(sum[:4])), nil
+}
+
+// TimestampName adds the modification time of the file to the front of the
+// base name, like "20200102-150405_report.pdf".
+func TimestampName(root, path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fi.ModTime().Format("20060102-150405") + "_" + filepath.Base(path), nil
+}
+
+// addSuffix inserts suffix before the extension of name.
+func addSuffix(name, suffix string) string {
+	ext := filepath.Ext(name)
+	if ext == name {
+		// a dot file like ".profile" has no extension
+		ext = ""
+	}
+	return strings.TrimSuffix(name, ext) + suffix + ext
+}
+
+// localFile is a file to upload.
+type localFile struct {
+	path string
+	// root is the path given to Upload that path was found in
+	root string
+	// name is the file name in the transfer
+	name string
+	size int64
+	// skip is set for files left out by CollisionSkip
+	skip bool
+}
+
+// listLocalFiles is like listFilesInPath, but keeps the root of every file.
+func listLocalFiles(fspath ...string) ([]localFile, error) {
+	files := []localFile{}
+	for _, root := range fspath {
+		filePaths, _, err := listFilesInPath(root)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range filePaths {
+			fi, err := os.Stat(v)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read file %s: %w", v, err)
+			}
+			files = append(files, localFile{path: v, root: root, size: fi.Size()})
+		}
+	}
+	return files, nil
+}
+
+// nameFiles names files with NameFunc, and applies CollisionPolicy. Files
+// are named in place, and marked if left out by CollisionSkip. Collisions are
+// returned whatever the policy. With CollisionFail, they come with an error
+// wrapping ErrNameCollision.
+func (cc *CowClient) nameFiles(files []localFile) ([]NameCollision, error) {
+	if cc.CollisionPolicy < CollisionRename || cc.CollisionPolicy > CollisionSkip {
+		return nil, fmt.Errorf("unknown collision policy %d", cc.CollisionPolicy)
+	}
+	nameFunc := cc.NameFunc
+	if nameFunc == nil {
+		nameFunc = BaseName
+	}
+
+	paths := map[string][]string{}
+	order := []string{}
+	for i, v := range files {
+		name, err := nameFunc(v.root, v.path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot name %s: %w", v.path, err)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("cannot name %s: empty name", v.path)
+		}
+		files[i].name = name
+		if _, ok := paths[name]; !ok {
+			order = append(order, name)
+		}
+		paths[name] = append(paths[name], v.path)
+	}
+
+	collisions := []NameCollision{}
+	for _, name := range order {
+		if len(paths[name]) > 1 {
+			collisions = append(collisions, NameCollision{Name: name, Paths: paths[name]})
+		}
+	}
+	if len(collisions) > 0 && cc.CollisionPolicy == CollisionFail {
+		err := fmt.Errorf("%w: %s (%s)", ErrNameCollision, collisions[0].Name, strings.Join(collisions[0].Paths, ", "))
+		if len(collisions) > 1 {
+			err = fmt.Errorf("%w and %d more", err, len(collisions)-1)
+		}
+		return collisions, err
+	}
+
+	used := map[string]bool{}
+	for i, v := range files {
+		if !used[v.name] {
+			used[v.name] = true
+			continue
+		}
+		if cc.CollisionPolicy == CollisionSkip {
+			files[i].skip = true
+			continue
+		}
+		name := v.name
+		for n := 2; used[name]; n++ {
+			name = addSuffix(v.name, fmt.Sprintf("-%d", n))
+		}
+		used[name] = true
+		files[i].name = name
+	}
+	return collisions, nil
+}
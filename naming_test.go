@@ -0,0 +1,157 @@
+package cowtransfer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestNameFuncs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "photos", "2020", "a.jpg")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 15, 4, 5, 0, time.Local)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "photos")
+
+	for _, test := range []struct {
+		name   string
+		fn     NameFunc
+		root   string
+		expect string
+	}{
+		{"BaseName", BaseName, root, "^a.jpg$"},
+		{"RelativeName", RelativeName, root, "^photos/2020/a.jpg$"},
+		{"RelativeName of file", RelativeName, path, "^a.jpg$"},
+		{"HashSuffixName", HashSuffixName, root, "^a-[0-9a-f]{8}.jpg$"},
+		{"TimestampName", TimestampName, root, "^20200102-150405_a.jpg$"},
+	} {
+		got, err := test.fn(test.root, path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !regexp.MustCompile(test.expect).MatchString(got) {
+			t.Errorf("%s: expect %s, got %s", test.name, test.expect, got)
+		}
+	}
+
+	if addSuffix(".profile", "-2") != ".profile-2" || addSuffix("a.tar.gz", "-2") != "a.tar-2.gz" {
+		t.Errorf("unexpected suffixes: %s %s", addSuffix(".profile", "-2"), addSuffix("a.tar.gz", "-2"))
+	}
+}
+
+func TestNameFiles(t *testing.T) {
+	newFiles := func() []localFile {
+		return []localFile{
+			{path: "x/a.txt", root: "x"},
+			{path: "y/a.txt", root: "y"},
+			{path: "y/a-2.txt", root: "y"},
+			{path: "z/a.txt", root: "z"},
+		}
+	}
+	cc := NewClient()
+	cc.CollisionPolicy = CollisionFail
+
+	collisions, err := cc.nameFiles(newFiles())
+	if !errors.Is(err, ErrNameCollision) {
+		t.Errorf("expected ErrNameCollision, got %v", err)
+	}
+	if len(collisions) != 1 || len(collisions[0].Paths) != 3 {
+		t.Errorf("unexpected collisions: %+v", collisions)
+	}
+
+	// later files are renamed by default
+	files := newFiles()
+	if _, err := NewClient().nameFiles(files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, v := range files {
+		names = append(names, v.name)
+	}
+	// y/a-2.txt comes after y/a.txt took its name
+	if names[0] != "a.txt" || names[1] != "a-2.txt" || names[2] != "a-2-2.txt" || names[3] != "a-3.txt" {
+		t.Errorf("unexpected names: %v", names)
+	}
+
+	cc.CollisionPolicy = CollisionSkip
+	files = newFiles()
+	if _, err := cc.nameFiles(files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files[0].skip || !files[1].skip || files[2].skip || !files[3].skip {
+		t.Errorf("unexpected skips: %+v", files)
+	}
+}
+
+func TestUploadCollision(t *testing.T) {
+	ts := newFakeUploadServer(t)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "x", "a.txt"), filepath.Join(dir, "y", "a.txt")}
+	for _, v := range paths {
+		if err := os.MkdirAll(filepath.Dir(v), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(v, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cc := NewClient()
+	cc.APIURL = ts.URL
+	cc.OSSURL = ts.URL
+	cc.VerifyHash = false
+	cc.CollisionPolicy = CollisionFail
+	opened := false
+	cc.OnStart(func(s *UploadSession) {
+		opened = true
+	})
+	if _, err := cc.UploadWithResult(nil, paths...); !errors.Is(err, ErrNameCollision) {
+		t.Errorf("expected ErrNameCollision, got %v", err)
+	}
+	if opened {
+		t.Errorf("session opened despite the collision")
+	}
+
+	cc.CollisionPolicy = CollisionSkip
+	result, err := cc.UploadWithResult(nil, paths...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Files[0].Status != FileUploaded || result.Files[1].Status != FileSkipped || !errors.Is(result.Files[1].Error, ErrNameCollision) {
+		t.Errorf("unexpected results: %+v %+v", result.Files[0], result.Files[1])
+	}
+
+	// the default renames the second file
+	cc.CollisionPolicy = NewClient().CollisionPolicy
+	result, err = cc.UploadWithResult(nil, paths...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Files[0].Name != "a.txt" || result.Files[1].Name != "a-2.txt" || result.Uploaded() != 2 {
+		t.Errorf("unexpected results: %+v %+v", result.Files[0], result.Files[1])
+	}
+
+	cc.CollisionPolicy = CollisionFail
+	cc.NameFunc = RelativeName
+	plan, err := cc.Plan(filepath.Join(dir, "x"), filepath.Join(dir, "y"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.Err() != nil || len(plan.Collisions) != 0 || plan.Files[0].Name != "x/a.txt" || plan.Files[1].Name != "y/a.txt" {
+		t.Errorf("unexpected plan: %+v", plan)
+	}
+}
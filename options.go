@@ -0,0 +1,84 @@
+package cowtransfer
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	maxTransferNameLength    = 255
+	maxTransferMessageLength = 1000
+	maxTransferValidDays     = 365
+)
+
+// TransferOptions are settings for a new transfer. The zero value uses the 
+// site defaults.
+type TransferOptions struct {
+	// Name is the display name of the transfer.
+	Name string `json:"name"`
+	// Message is shown to recipients on the download page.
+	Message string `json:"message"`
+	// ValidDays is the number of days the transfer stays available. Zero 
+	// uses the site default.
+	ValidDays int `json:"valid_days"`
+	// MaxDownloads is the number of downloads allowed. Zero means unlimited.
+	MaxDownloads int `json:"max_downloads"`
+	// NotifyOnDownload sends a notification to the uploader account on every 
+	// download.
+	NotifyOnDownload bool `json:"notify_on_download"`
+}
+
+// Validate checks that all options are within the limits accepted by 
+// Cowtransfer. Errors returned wrap ErrTransferOptions.
+func (o *TransferOptions) Validate() error {
+	if utf8.RuneCountInString(o.Name) > maxTransferNameLength {
+		return fmt.Errorf("%w: name is longer than %d characters", ErrTransferOptions, maxTransferNameLength)
+	}
+	if utf8.RuneCountInString(o.Message) > maxTransferMessageLength {
+		return fmt.Errorf("%w: message is longer than %d characters", ErrTransferOptions, maxTransferMessageLength)
+	}
+	if o.ValidDays < 0 || o.ValidDays > maxTransferValidDays {
+		return fmt.Errorf("%w: valid days must be between 0 and %d (0 uses the default)", ErrTransferOptions, maxTransferValidDays)
+	}
+	if o.MaxDownloads < 0 {
+		return fmt.Errorf("%w: max downloads cannot be negative", ErrTransferOptions)
+	}
+	return nil
+}
+
+// formFields adds the options that differ from the site defaults to data.
+func (o *TransferOptions) formFields(data map[string]string) {
+	if o.Name != "" {
+		data["transferName"] = o.Name
+	}
+	if o.Message != "" {
+		data["message"] = o.Message
+	}
+
+	change := SettingsChange{}
+	if o.ValidDays > 0 {
+		change.ValidDays = &o.ValidDays
+	}
+	if o.MaxDownloads > 0 {
+		change.MaxDownloads = &o.MaxDownloads
+	}
+	if o.NotifyOnDownload {
+		change.NotifyOnDownload = &o.NotifyOnDownload
+	}
+	change.formFields(data)
+}
+
+// formFields adds the settings set in c to data. The same fields are used 
+// when a transfer is created and when it is changed.
+func (c *SettingsChange) formFields(data map[string]string) {
+	if c.ValidDays != nil {
+		data["validDays"] = strconv.Itoa(*c.ValidDays)
+	}
+	if c.MaxDownloads != nil {
+		data["downloadTimes"] = strconv.Itoa(*c.MaxDownloads)
+	}
+	if c.NotifyOnDownload != nil {
+		data["notifyOnDownload"] = strconv.FormatBool(*c.NotifyOnDownload)
+	}
+}
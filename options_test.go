@@ -0,0 +1,55 @@
+package cowtransfer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTransferOptionsValidate(t *testing.T) {
+	cases := []struct {
+		opts TransferOptions
+		ok   bool
+	}{
+		{TransferOptions{}, true},
+		{TransferOptions{Name: "backup", Message: "hi", ValidDays: 7, MaxDownloads: 3, NotifyOnDownload: true}, true},
+		{TransferOptions{Name: strings.Repeat("a", maxTransferNameLength+1)}, false},
+		{TransferOptions{Message: strings.Repeat("a", maxTransferMessageLength+1)}, false},
+		{TransferOptions{ValidDays: -1}, false},
+		{TransferOptions{ValidDays: maxTransferValidDays + 1}, false},
+		{TransferOptions{MaxDownloads: -1}, false},
+	}
+
+	for i, c := range cases {
+		err := c.opts.Validate()
+		if c.ok && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if !c.ok && !errors.Is(err, ErrTransferOptions) {
+			t.Errorf("case %d: expected ErrTransferOptions, got %v", i, err)
+		}
+	}
+}
+
+func TestTransferOptionsFormFields(t *testing.T) {
+	data := map[string]string{}
+	(&TransferOptions{}).formFields(data)
+	if len(data) != 0 {
+		t.Errorf("expected no fields for defaults, got %v", data)
+	}
+
+	opts := TransferOptions{Name: "backup", Message: "hi", ValidDays: 7, MaxDownloads: 3, NotifyOnDownload: true}
+	opts.formFields(data)
+	expect := map[string]string{
+		"transferName":     "backup",
+		"message":          "hi",
+		"validDays":        "7",
+		"downloadTimes":    "3",
+		"notifyOnDownload": "true",
+	}
+	for k, v := range expect {
+		if data[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, data[k])
+		}
+	}
+}
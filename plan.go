@@ -0,0 +1,160 @@
+package cowtransfer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// API calls made by an upload, besides block pushes.
+const (
+	// create and complete the session
+	sessionAPICalls = 2
+	// request the upload, init the blocks upload, merge the blocks and
+	// confirm the upload
+	fileAPICalls = 4
+)
+
+// UploadPlan describes what Upload would do with a list of files, without
+// contacting the network.
+type UploadPlan struct {
+	// Files are the files to upload, in order.
+	Files []PlannedFile `json:"files"`
+	// Collisions are the names that NameFunc gave to more than one file,
+	// before CollisionPolicy is applied.
+	Collisions []NameCollision `json:"collisions"`
+	// Skipped are the paths that will not be uploaded, and why. Apart from
+	// files left out by CollisionSkip, Upload fails on them, so leave them
+	// out first.
+	Skipped []SkippedPath `json:"skipped"`
+	// TotalSize is the size of all files.
+	TotalSize int64 `json:"total_size"`
+	// TotalBlocks is the number of blocks to push.
+	TotalBlocks int64 `json:"total_blocks"`
+	// BlockSize and MaxPushBlocks are the client settings used.
+	BlockSize     int `json:"block_size"`
+	MaxPushBlocks int `json:"max_push_blocks"`
+	// APICalls is the number of API requests, if no block push is retried.
+	APICalls int64 `json:"api_calls"`
+	// err is why Upload would fail
+	err error
+}
+
+// PlannedFile is a file in an UploadPlan.
+type PlannedFile struct {
+	Path string `json:"path"`
+	// Name is the file name in the transfer.
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Blocks int64  `json:"blocks"`
+}
+
+// NameCollision lists the files that share a remote name.
+type NameCollision struct {
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+}
+
+// SkippedPath is a path that cannot be uploaded, and why.
+type SkippedPath struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// Plan resolves and names files like Upload does, and returns what would be
+// uploaded. Paths that cannot be uploaded and name collisions are reported in
+// the plan rather than as an error. An error is returned if BlockSize is out
+// of range, or if NameFunc fails.
+func (cc *CowClient) Plan(files ...string) (*UploadPlan, error) {
+	if cc.BlockSize < minBlockSize || cc.BlockSize > maxBlockSize {
+		return nil, fmt.Errorf("%w: %d is not between %d and %d", ErrBlockSize, cc.BlockSize, minBlockSize, maxBlockSize)
+	}
+
+	plan := &UploadPlan{
+		Files:         []PlannedFile{},
+		Collisions:    []NameCollision{},
+		Skipped:       []SkippedPath{},
+		BlockSize:     cc.BlockSize,
+		MaxPushBlocks: cc.MaxPushBlocks,
+		APICalls:      sessionAPICalls,
+	}
+	if cc.Password != "" {
+		plan.APICalls++
+	}
+
+	skip := func(path string, err error) {
+		plan.Skipped = append(plan.Skipped, SkippedPath{Path: path, Reason: err.Error()})
+		if plan.err == nil {
+			plan.err = err
+		}
+	}
+
+	localFiles := []localFile{}
+	for _, v := range files {
+		// one path at a time, so that a bad path doesn't hide the others
+		filePaths, _, err := listFilesInPath(v)
+		if err != nil {
+			skip(v, err)
+			continue
+		}
+		for _, path := range filePaths {
+			fi, err := os.Stat(path)
+			if err != nil {
+				skip(path, err)
+				continue
+			}
+			if blocksInFile(fi.Size(), cc.BlockSize) > maxBlocks {
+				skip(path, fmt.Errorf("%w: %s needs more than %d blocks", ErrBlockSize, path, maxBlocks))
+				continue
+			}
+			localFiles = append(localFiles, localFile{path: path, root: v, size: fi.Size()})
+		}
+	}
+
+	collisions, err := cc.nameFiles(localFiles)
+	if err != nil && !errors.Is(err, ErrNameCollision) {
+		return nil, err
+	}
+	if err != nil && plan.err == nil {
+		plan.err = err
+	}
+	plan.Collisions = collisions
+
+	for _, v := range localFiles {
+		if v.skip {
+			plan.Skipped = append(plan.Skipped, SkippedPath{
+				Path:   v.path,
+				Reason: fmt.Sprintf("%v: %s", ErrNameCollision, v.name),
+			})
+			continue
+		}
+		blocks := blocksInFile(v.size, cc.BlockSize)
+		plan.Files = append(plan.Files, PlannedFile{
+			Path:   v.path,
+			Name:   v.name,
+			Size:   v.size,
+			Blocks: blocks,
+		})
+		plan.TotalSize += v.size
+		plan.TotalBlocks += blocks
+		plan.APICalls += fileAPICalls + blocks
+	}
+	return plan, nil
+}
+
+// Err returns the error that Upload would fail with, or nil.
+func (p *UploadPlan) Err() error {
+	return p.err
+}
+
+// Estimate returns how long the upload would take at throughput bytes per
+// second, if every API call other than a block push takes latency. Blocks
+// pushed in parallel are assumed to share throughput.
+func (p *UploadPlan) Estimate(throughput float64, latency time.Duration) time.Duration {
+	if throughput <= 0 {
+		return 0
+	}
+	transfer := time.Duration(float64(p.TotalSize) / throughput * float64(time.Second))
+	return transfer + time.Duration(p.APICalls-p.TotalBlocks)*latency
+}
@@ -0,0 +1,69 @@
+package cowtransfer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, size int) string {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	big := write("big.bin", minBlockSize*2+1)
+	write("sub/a.txt", 10)
+	write("other/a.txt", 20)
+	missing := filepath.Join(dir, "missing")
+
+	cc := NewClient()
+	cc.BlockSize = minBlockSize
+	cc.Password = "secret"
+	plan, err := cc.Plan(big, filepath.Join(dir, "sub"), missing, filepath.Join(dir, "other"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(plan.Files) != 3 {
+		t.Fatalf("expected 3 files, got %+v", plan.Files)
+	}
+	if plan.Files[0].Name != "big.bin" || plan.Files[0].Blocks != 3 {
+		t.Errorf("unexpected plan for big.bin: %+v", plan.Files[0])
+	}
+	if plan.TotalSize != minBlockSize*2+31 || plan.TotalBlocks != 5 {
+		t.Errorf("expected size %d and 5 blocks, got %d and %d", minBlockSize*2+31, plan.TotalSize, plan.TotalBlocks)
+	}
+	// session 2 + password 1 + per file 4 + blocks 5
+	if plan.APICalls != 20 {
+		t.Errorf("expected 20 API calls, got %d", plan.APICalls)
+	}
+	if len(plan.Skipped) != 1 || plan.Skipped[0].Path != missing {
+		t.Errorf("expected %s to be skipped, got %+v", missing, plan.Skipped)
+	}
+	if len(plan.Collisions) != 1 || plan.Collisions[0].Name != "a.txt" || len(plan.Collisions[0].Paths) != 2 {
+		t.Errorf("expected a.txt to collide, got %+v", plan.Collisions)
+	}
+	if plan.Err() == nil {
+		t.Errorf("expected an error for %s", missing)
+	}
+
+	// 15 calls other than block pushes at 100ms, plus 1 second of data
+	plan.TotalSize = 1000
+	if d := plan.Estimate(1000, 100*time.Millisecond); d != time.Second+15*100*time.Millisecond {
+		t.Errorf("unexpected estimate: %v", d)
+	}
+
+	cc.BlockSize = 1
+	if _, err := cc.Plan(big); !errors.Is(err, ErrBlockSize) {
+		t.Errorf("expected ErrBlockSize, got %v", err)
+	}
+}
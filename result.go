@@ -0,0 +1,99 @@
+package cowtransfer
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FileStatus is the outcome of uploading a file.
+type FileStatus string
+
+const (
+	// FileUploaded means that the file was uploaded.
+	FileUploaded FileStatus = "uploaded"
+	// FileSkipped means that the push block error handler skipped the file.
+	FileSkipped FileStatus = "skipped"
+	// FileFailed means that the file could not be uploaded, which aborts the
+	// session.
+	FileFailed FileStatus = "failed"
+	// FilePending means that the file was not tried, because the session was
+	// aborted before.
+	FilePending FileStatus = "pending"
+)
+
+// UploadResult describes an upload session and the outcome of every file.
+type UploadResult struct {
+	TransferGUID string `json:"transfer_guid"`
+	UniqueURL    string `json:"url"`
+	Prefix       string `json:"prefix"`
+	// TempCode is the temporary download code. It is empty if the session
+	// was not closed.
+	TempCode string        `json:"temp_download_code"`
+	Started  time.Time     `json:"started"`
+	Finished time.Time     `json:"finished"`
+	Files    []*FileResult `json:"files"`
+	// Manifest has the hashes of all uploaded files if RecordHashes is set.
+	Manifest *Manifest `json:"manifest,omitempty"`
+}
+
+// FileResult describes the upload of a file.
+type FileResult struct {
+	// Path to file on local filesystem.
+	Path string `json:"path"`
+	// Name is the file name in the transfer.
+	Name string `json:"name"`
+	// FileGUID identifies the file in the transfer.
+	FileGUID string `json:"file_guid,omitempty"`
+	Size     int64  `json:"size"`
+	// Blocks is the number of blocks of the file.
+	Blocks int64 `json:"blocks"`
+	// Retries is the number of times a block push was tried again.
+	Retries int64 `json:"retries"`
+	// Duration is the time spent on the file, from the upload request to
+	// the merge of its blocks.
+	Duration time.Duration `json:"-"`
+	// Hash is the Qiniu hash returned when the blocks are merged.
+	Hash   string     `json:"hash,omitempty"`
+	Status FileStatus `json:"status"`
+	// Error is the reason the file was skipped or failed.
+	Error error `json:"-"`
+}
+
+// Throughput returns the average upload speed of the file in bytes per
+// second, or 0 if it was not uploaded.
+func (f *FileResult) Throughput() float64 {
+	if f.Status != FileUploaded || f.Duration <= 0 {
+		return 0
+	}
+	return float64(f.Size) / f.Duration.Seconds()
+}
+
+func (f *FileResult) MarshalJSON() ([]byte, error) {
+	type fileAlias FileResult
+	errMsg := ""
+	if f.Error != nil {
+		errMsg = f.Error.Error()
+	}
+	return json.Marshal(&struct {
+		*fileAlias
+		Duration   float64 `json:"duration"`
+		Throughput float64 `json:"throughput"`
+		Error      string  `json:"error,omitempty"`
+	}{
+		fileAlias:  (*fileAlias)(f),
+		Duration:   f.Duration.Seconds(),
+		Throughput: f.Throughput(),
+		Error:      errMsg,
+	})
+}
+
+// Uploaded returns the number of files that were uploaded.
+func (r *UploadResult) Uploaded() int {
+	count := 0
+	for _, v := range r.Files {
+		if v.Status == FileUploaded {
+			count++
+		}
+	}
+	return count
+}
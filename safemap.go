@@ -1,12 +1,14 @@
 package cowtransfer
 
 import (
+	"sort"
 	"sync"
 )
 
 type int64map struct {
 	hashmap map[int64]*uploadBlockResult
 	mutex sync.RWMutex 
+	abort error
 }
 
 type uploadBlockResult struct {
@@ -30,7 +32,9 @@ func (sm *int64map) Load(key int64) (string, error, bool) {
 	return result.token, result.err, ok
 }
 
-func (sm *int64map) Store(key int64, token string, size int) {
+// Store records a pushed block, and returns the number and total size of 
+// pushed blocks including it.
+func (sm *int64map) Store(key int64, token string, size int) (int64, int64) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
@@ -43,6 +47,7 @@ func (sm *int64map) Store(key int64, token string, size int) {
 		err: nil,
 		size: size,
 	}
+	return sm.size()
 }
 
 func (sm *int64map) StoreError(key int64, err error) {
@@ -62,16 +67,55 @@ func (sm *int64map) Size() (int64, int64) {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 
-	if sm.hashmap == nil {
-		sm.hashmap = map[int64]*uploadBlockResult{}
-	}
-	
+	return sm.size()
+}
+
+// size is Size with the lock held.
+func (sm *int64map) size() (int64, int64) {
 	blocksDone := int64(0)
 	sizeDone := int64(0)
 	for _, v := range sm.hashmap {
+		if v.err != nil {
+			continue
+		}
 		blocksDone ++
 		sizeDone += int64(v.size)
 	}
 
 	return blocksDone, sizeDone
+}
+
+// Failed returns the keys of all failed entries in ascending order.
+func (sm *int64map) Failed() []int64 {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	keys := []int64{}
+	for k, v := range sm.hashmap {
+		if v.err != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	return keys
+}
+
+// Abort records err as the reason to stop processing. Only the first call 
+// has any effect.
+func (sm *int64map) Abort(err error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if sm.abort == nil {
+		sm.abort = err
+	}
+}
+
+// Aborted returns the error passed to Abort, or nil.
+func (sm *int64map) Aborted() error {
+	sm.mutex.RLock()
+	defer sm.mutex.RUnlock()
+
+	return sm.abort
 }
\ No newline at end of file
@@ -0,0 +1,114 @@
+package cowtransfer
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	setPullPasswordURL = "%s/transfer/v2/bindpasscode"
+	deleteTransferURL  = "%s/transfer/delete"
+	updateTransferURL  = "%s/transfer/v2/updatesettings"
+)
+
+// Transfer is a handle to an uploaded transfer. Managing a transfer requires 
+// the same cookies that were used to upload it, so keep Cookies around 
+// (see CookieJar.Save) if you need to manage a transfer later.
+type Transfer struct {
+	// GUID is the transfer GUID, as in UploadSession.TransferGUID.
+	GUID string
+	cc   *CowClient
+}
+
+// Transfer returns a handle to manage the transfer with guid.
+func (cc *CowClient) Transfer(guid string) *Transfer {
+	return &Transfer{
+		GUID: guid,
+		cc:   cc,
+	}
+}
+
+// SetPassword protects the transfer from downloads with password. Any 
+// existing password is replaced.
+func (t *Transfer) SetPassword(password string) error {
+	if password == "" {
+		return errors.New("password cannot be empty")
+	}
+	return t.bindPassword(password)
+}
+
+// RemovePassword allows downloads without a password.
+func (t *Transfer) RemovePassword() error {
+	return t.bindPassword("")
+}
+
+func (t *Transfer) bindPassword(password string) error {
+	data := map[string]string{
+		"transferguid": t.GUID,
+		"passcode":     password,
+	}
+	return t.post(setPullPasswordURL, data)
+}
+
+// Delete removes the transfer. It cannot be downloaded afterwards.
+func (t *Transfer) Delete() error {
+	data := map[string]string{
+		"transferGuid": t.GUID,
+	}
+	return t.post(deleteTransferURL, data)
+}
+
+// Update replaces the validity, download limit and notification settings of 
+// the transfer with those of opts. Name and Message cannot be changed after 
+// upload, and are ignored.
+func (t *Transfer) Update(opts TransferOptions) error {
+	return t.Change(SettingsChange{
+		ValidDays:        &opts.ValidDays,
+		MaxDownloads:     &opts.MaxDownloads,
+		NotifyOnDownload: &opts.NotifyOnDownload,
+	})
+}
+
+// SettingsChange lists the settings of a transfer to change. Nil fields are 
+// not sent, so the server keeps their current values. Fields mean the same 
+// as in TransferOptions.
+type SettingsChange struct {
+	ValidDays        *int
+	MaxDownloads     *int
+	NotifyOnDownload *bool
+}
+
+// Change updates only the settings set in change. Use it instead of Update 
+// when the other settings are not known.
+func (t *Transfer) Change(change SettingsChange) error {
+	if change.ValidDays != nil && (*change.ValidDays < 1 || *change.ValidDays > maxTransferValidDays) {
+		return fmt.Errorf("valid days must be between 1 and %d", maxTransferValidDays)
+	}
+	if change.MaxDownloads != nil && *change.MaxDownloads < 0 {
+		return errors.New("max downloads cannot be negative")
+	}
+
+	data := map[string]string{}
+	change.formFields(data)
+	if len(data) == 0 {
+		return errors.New("no settings to change")
+	}
+	data["transferGuid"] = t.GUID
+	return t.post(updateTransferURL, data)
+}
+
+// post sends a management request. These APIs return "true" on success.
+func (t *Transfer) post(apiURL string, data map[string]string) error {
+	if t.GUID == "" {
+		return errors.New("transfer GUID cannot be empty")
+	}
+
+	body, err := t.cc.newMultipartFormRequest(fmt.Sprintf(apiURL, t.cc.APIURL), data)
+	if err != nil {
+		return err
+	}
+	if string(body) != "true" {
+		return ErrInvalidResponse
+	}
+	return nil
+}
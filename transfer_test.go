@@ -0,0 +1,98 @@
+package cowtransfer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// transferServer is a stand-in for the transfer management APIs. It records
+// the form fields of the last request made to each path.
+type transferServer struct {
+	*httptest.Server
+	forms map[string]map[string]string
+}
+
+func newTransferServer() *transferServer {
+	ts := &transferServer{forms: map[string]map[string]string{}}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form := map[string]string{}
+		for k, v := range r.MultipartForm.Value {
+			form[k] = v[0]
+		}
+		ts.forms[r.URL.Path] = form
+
+		if form["transferguid"] == "unknown" || form["transferGuid"] == "unknown" {
+			fmt.Fprint(w, "false")
+			return
+		}
+		fmt.Fprint(w, "true")
+	}))
+	return ts
+}
+
+func TestTransferManagement(t *testing.T) {
+	ts := newTransferServer()
+	defer ts.Close()
+
+	cc := NewClient()
+	cc.APIURL = ts.URL
+	tr := cc.Transfer("guid1")
+
+	if err := tr.SetPassword("secret"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if form := ts.forms["/transfer/v2/bindpasscode"]; form["transferguid"] != "guid1" || form["passcode"] != "secret" {
+		t.Errorf("SetPassword sent %v", form)
+	}
+	if err := tr.SetPassword(""); err == nil {
+		t.Errorf("SetPassword accepted empty password")
+	}
+
+	if err := tr.RemovePassword(); err != nil {
+		t.Fatalf("RemovePassword: %v", err)
+	}
+	if form := ts.forms["/transfer/v2/bindpasscode"]; form["passcode"] != "" {
+		t.Errorf("RemovePassword sent %v", form)
+	}
+
+	err := tr.Update(TransferOptions{ValidDays: 7, MaxDownloads: 3, NotifyOnDownload: true})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	form := ts.forms["/transfer/v2/updatesettings"]
+	if form["validDays"] != "7" || form["downloadTimes"] != "3" || form["notifyOnDownload"] != "true" {
+		t.Errorf("Update sent %v", form)
+	}
+	if err := tr.Update(TransferOptions{}); err == nil {
+		t.Errorf("Update accepted zero valid days")
+	}
+
+	maxDownloads := 5
+	if err := tr.Change(SettingsChange{MaxDownloads: &maxDownloads}); err != nil {
+		t.Fatalf("Change: %v", err)
+	}
+	form = ts.forms["/transfer/v2/updatesettings"]
+	if _, ok := form["validDays"]; ok || form["downloadTimes"] != "5" || len(form) != 2 {
+		t.Errorf("Change sent %v", form)
+	}
+	if err := tr.Change(SettingsChange{}); err == nil {
+		t.Errorf("Change accepted no settings")
+	}
+
+	if err := tr.Delete(); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if form := ts.forms["/transfer/delete"]; form["transferGuid"] != "guid1" {
+		t.Errorf("Delete sent %v", form)
+	}
+
+	if err := cc.Transfer("unknown").Delete(); err != ErrInvalidResponse {
+		t.Errorf("expected ErrInvalidResponse, got %v", err)
+	}
+}
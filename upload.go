@@ -3,8 +3,12 @@ package cowtransfer
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -12,6 +16,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,13 +26,17 @@ const (
 	defaultOSSURL         = "https://upload-fog-cn-east-1.qiniup.com"
 	// restful apis for cowtransfer and qiniu oss
 	createUploadSessionURL = "%s/transfer/preparesend"
-	setPullPasswordURL     = "%s/transfer/v2/bindpasscode"
 	finishUploadSessionURL = "%s/transfer/complete"
 	uploadFileURL          = "%s/transfer/beforeupload"
 	finishUploadFileURL    = "%s/transfer/uploaded"
 	ossInitPushURL         = "%s/buckets/cowtransfer-yz/objects/%s/uploads"
 	ossPushBlockURL        = "%s/buckets/cowtransfer-yz/objects/%s/uploads/%s/%d"
 	ossFinishPushURL       = "%s/buckets/cowtransfer-yz/objects/%s/uploads/%s"
+	// qiniu multipart upload v2 limits. The last block of a file may be 
+	// smaller than minBlockSize.
+	minBlockSize = 1048576    // 1mb
+	maxBlockSize = 1073741824 // 1gb
+	maxBlocks    = 10000
 )
 
 // uploadSessionResponse is expected response from createUploadSessionURL API.
@@ -40,6 +49,8 @@ type uploadSessionResponse struct {
 	QRCode       string `json:"qrcode"`
 	Error        bool   `json:"error"`
 	ErrorMessage string `json:"error_message"`
+	// manifest collects file hashes if RecordHashes is set
+	manifest *Manifest
 }
 
 // uploadSessionFinish is expected response from finishUploadSessionURL API. 
@@ -89,72 +100,187 @@ type ossPushBlockResponse struct {
 	MD5  string `json:"md5"`
 }
 
+// fileBlockUpload describes a block for uploadFileBlock to read and push.
 type fileBlockUpload struct {
 	filePath    string
 	fileSize    int64
-	content     []byte
+	section     *io.SectionReader
 	count       int64
 	totalBlocks int64
+	// pieces receives the block hash at index count-1, if not nil
+	pieces      []string
+	// retries counts block push retries of the file
+	retries     *int64
 }
 
 // Upload a list of files to CowTransfer. Returns the unique download URL if 
 // all uploads are successful.
 func (cc *CowClient) Upload(files ...string) (string, error) {
-	filePaths, totalSize, err := listFilesInPath(files...)
+	return cc.UploadWithOptions(nil, files...)
+}
+
+// UploadWithOptions is like Upload, but creates the transfer with opts. A nil 
+// opts uses the site defaults.
+func (cc *CowClient) UploadWithOptions(opts *TransferOptions, files ...string) (string, error) {
+	result, err := cc.UploadWithResult(opts, files...)
 	if err != nil {
 		return "", err
 	}
+	return result.UniqueURL, nil
+}
+
+// UploadWithResult is like UploadWithOptions, but returns the outcome of the 
+// session and of every file. Once the session is open, the result is returned 
+// on failure too, with the files not uploaded marked as failed or pending.
+func (cc *CowClient) UploadWithResult(opts *TransferOptions, files ...string) (*UploadResult, error) {
+	if opts == nil {
+		opts = &TransferOptions{}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
 
-	session, err := cc.newUploadSession(totalSize)
+	localFiles, err := listLocalFiles(files...)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	if cc.openSessionHook != nil {
-		cc.openSessionHook(&UploadSession{
-			UploadToken: session.UploadToken,
-			TransferGUID: session.TransferGUID,
-			FileGUID: session.FileGUID,
-			UniqueURL: session.UniqueURL,
-			Prefix: session.Prefix,
-			QRCode: session.QRCode,
-			TempCode: "",
-		})
+	// names are checked before the session, so that a collision doesn't 
+	// leave behind an empty transfer
+	if _, err := cc.nameFiles(localFiles); err != nil {
+		return nil, err
+	}
+	filePaths := []string{}
+	totalSize := int64(0)
+	for _, v := range localFiles {
+		if !v.skip {
+			filePaths = append(filePaths, v.path)
+			totalSize += v.size
+		}
+	}
+	if err := cc.checkBlockSize(filePaths); err != nil {
+		return nil, err
 	}
 
-	for _, v := range filePaths {
+	if cc.EventDelivery == SerialDelivery {
+		cc.events.startSerial()
+		defer cc.events.stopSerial()
+	}
+
+	started := time.Now()
+	session, err := cc.newUploadSession(totalSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	if cc.RecordHashes {
+		session.manifest = &Manifest{Files: []ManifestFile{}}
+	}
+	cc.logger().Info("session open", "guid", session.TransferGUID, "url", session.UniqueURL, "files", len(filePaths), "size", totalSize)
+	cc.emitSession(&UploadSession{
+		UploadToken: session.UploadToken,
+		TransferGUID: session.TransferGUID,
+		FileGUID: session.FileGUID,
+		UniqueURL: session.UniqueURL,
+		Prefix: session.Prefix,
+		QRCode: session.QRCode,
+		TempCode: "",
+	}, false)
+
+	result := &UploadResult{
+		TransferGUID: session.TransferGUID,
+		UniqueURL: session.UniqueURL,
+		Prefix: session.Prefix,
+		Started: started,
+		Files: []*FileResult{},
+		Manifest: session.manifest,
+	}
+	for _, v := range localFiles {
+		fr := &FileResult{
+			Path: v.path,
+			Name: v.name,
+			Size: v.size,
+			Status: FilePending,
+		}
+		if v.skip {
+			fr.Status = FileSkipped
+			fr.Error = ErrNameCollision
+		}
+		result.Files = append(result.Files, fr)
+	}
+
+	pool := newBlockPool(cc.BlockSize, cc.MaxMemory)
+	for _, v := range result.Files {
+		if v.Status == FileSkipped {
+			cc.logger().Warn("file skipped", "path", v.Path, "name", v.Name, "error", v.Error)
+			continue
+		}
+		fileStarted := time.Now()
 		if cc.MaxPushBlocks < 2 {
-			err = cc.uploadFileBlocksSerial(v, session)
+			err = cc.uploadFileBlocksSerial(v, session, pool)
 		} else {
-			err = cc.uploadFileBlocksParallel(v, session)
+			err = cc.uploadFileBlocksParallel(v, session, pool)
+		}
+		v.Duration = time.Since(fileStarted)
+		if err == ErrSkipFile {
+			cc.logger().Warn("file skipped", "path", v.Path)
+			v.Status = FileSkipped
+			v.Error = err
+			continue
 		}
 		if err != nil {
-			return "", err
+			cc.logger().Error("upload failed", "guid", session.TransferGUID, "path", v.Path, "error", err)
+			v.Status = FileFailed
+			v.Error = err
+			result.Finished = time.Now()
+			return result, err
 		}
+		v.Status = FileUploaded
 	}
 
 	tmpCode, err := cc.finishUploadSession(session)
+	result.Finished = time.Now()
 	if err != nil {
-		return "", err
+		return result, err
 	}
-	if cc.closeSessionHook != nil {
-		cc.closeSessionHook(&UploadSession{
-			UploadToken: session.UploadToken,
-			TransferGUID: session.TransferGUID,
-			FileGUID: session.FileGUID,
-			UniqueURL: session.UniqueURL,
-			Prefix: session.Prefix,
-			QRCode: session.QRCode,
-			TempCode: tmpCode,
-		})
+	result.TempCode = tmpCode
+	cc.logger().Info("session close", "guid", session.TransferGUID, "url", session.UniqueURL)
+	cc.emitSession(&UploadSession{
+		UploadToken: session.UploadToken,
+		TransferGUID: session.TransferGUID,
+		FileGUID: session.FileGUID,
+		UniqueURL: session.UniqueURL,
+		Prefix: session.Prefix,
+		QRCode: session.QRCode,
+		TempCode: tmpCode,
+		Manifest: session.manifest,
+	}, true)
+
+	return result, nil
+}
+
+// checkBlockSize makes sure that BlockSize is within Qiniu part size limits, 
+// and that no file needs more parts than Qiniu allows.
+func (cc *CowClient) checkBlockSize(filePaths []string) error {
+	if cc.BlockSize < minBlockSize || cc.BlockSize > maxBlockSize {
+		return fmt.Errorf("%w: %d is not between %d and %d", ErrBlockSize, cc.BlockSize, minBlockSize, maxBlockSize)
 	}
 
-	return session.UniqueURL, nil
+	for _, v := range filePaths {
+		fi, err := os.Stat(v)
+		if err != nil {
+			return fmt.Errorf("cannot read file %s: %w", v, err)
+		}
+		if blocksInFile(fi.Size(), cc.BlockSize) > maxBlocks {
+			return fmt.Errorf("%w: %s needs more than %d blocks", ErrBlockSize, v, maxBlocks)
+		}
+	}
+	return nil
 }
 
-func (cc *CowClient) newUploadSession(totalSize int64) (*uploadSessionResponse, error) {
+func (cc *CowClient) newUploadSession(totalSize int64, opts *TransferOptions) (*uploadSessionResponse, error) {
 	data := map[string]string{
 		"totalSize": strconv.FormatInt(totalSize, 10),
 	}
+	opts.formFields(data)
 	body, err := cc.newMultipartFormRequest(fmt.Sprintf(createUploadSessionURL, cc.APIURL), data)
 	if err != nil {
 		return nil, err
@@ -170,18 +296,9 @@ func (cc *CowClient) newUploadSession(totalSize int64) (*uploadSessionResponse,
 	}
 
 	if cc.Password != "" {
-		// set password
-		data := map[string]string{
-			"transferguid": session.TransferGUID,
-			"passcode":     cc.Password,
-		}
-		body, err = cc.newMultipartFormRequest(setPullPasswordURL, data)
-		if err != nil {
+		if err := cc.Transfer(session.TransferGUID).SetPassword(cc.Password); err != nil {
 			return nil, err
 		}
-		if string(body) != "true" {
-			return nil, ErrInvalidResponse
-		}
 	}
 	return session, nil
 }
@@ -199,7 +316,7 @@ func (cc *CowClient) finishUploadSession(s *uploadSessionResponse) (string, erro
 
 	var response uploadSessionFinishResponse
 	if err := json.Unmarshal(bodyBytes, &response); err != nil {
-		return "", fmt.Errorf("cannot marshal response: %v", err)
+		return "", fmt.Errorf("cannot marshal response: %w", err)
 	}
 	if !response.Status {
 		return response.TempDownloadCode, fmt.Errorf("complete status is false")
@@ -208,18 +325,22 @@ func (cc *CowClient) finishUploadSession(s *uploadSessionResponse) (string, erro
 	return response.TempDownloadCode, nil
 }
 
-// uploadFileBlocksSerial uploads a file one block at a time.
-func (cc *CowClient) uploadFileBlocksSerial(filePath string, session *uploadSessionResponse) error {
+// uploadFileBlocksSerial uploads a file one block at a time. What is learned 
+// about the file is recorded in fr.
+func (cc *CowClient) uploadFileBlocksSerial(fr *FileResult, session *uploadSessionResponse, pool *blockPool) error {
+	filePath := fr.Path
 	fi, err := os.Stat(filePath)
 	if err != nil {
-		return fmt.Errorf("cannot read file %s: %v", filePath, err)
+		return fmt.Errorf("cannot read file %s: %w", filePath, err)
 	}
 	// estimate the total number of blocks to upload
 	fileSize := fi.Size()
 	totalBlocks := blocksInFile(fileSize, cc.BlockSize)
+	fr.Size = fileSize
+	fr.Blocks = totalBlocks
 
-	if cc.transferProgressHook != nil {
-		cc.transferProgressHook(&FileTransfer{
+	if cc.events.active() {
+		cc.emitTransfer(&FileTransfer{
 			Path: filePath,
 			State: InitTransfer,
 			Size: fileSize,
@@ -227,95 +348,105 @@ func (cc *CowClient) uploadFileBlocksSerial(filePath string, session *uploadSess
 		})
 	}
 
-	uploadJob, err := cc.newFileUpload(fi, session)
+	uploadJob, err := cc.newFileUpload(fr.Name, fileSize, session)
 	if err != nil {
 		return err
 	}
+	fr.FileGUID = uploadJob.FileGUID
 
 	uploadFile, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("cannot open file %s: %v", filePath, err)
+		return fmt.Errorf("cannot open file %s: %w", filePath, err)
+	}
+	defer uploadFile.Close()
+
+	// a single buffer is reused for every block
+	buffer := pool.Get()
+	defer pool.Put(buffer)
+
+	var fileHash hash.Hash
+	pieces := []string{}
+	if session.manifest != nil {
+		fileHash = sha256.New()
 	}
 
+	reader := io.NewSectionReader(uploadFile, 0, fileSize)
 	hashmap := map[int64]string{}
 	parts := int64(0)
+	readSize := int64(0)
 	for {
-		buffer := make([]byte, cc.BlockSize)
-		nr, err := uploadFile.Read(buffer)
-		// #todo handle err
-		if nr <= 0 || err != nil {
+		// every block except the last must be exactly BlockSize
+		nr, err := io.ReadFull(reader, buffer)
+		if err == io.EOF {
 			break
 		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("cannot read block %d of %s: %w", parts+1, filePath, err)
+		}
 		parts++
-		if nr > 0 {
-			putURL := fmt.Sprintf(ossPushBlockURL, cc.OSSURL, uploadJob.EncodeID, uploadJob.ID, parts)
-
-			if cc.transferProgressHook != nil {
-				cc.transferProgressHook(&FileTransfer{
-					Path: filePath,
-					Size: fileSize,
-					State: DoBlock,
-					BlockSize: nr,
-					BlockNumber: parts,
-					Blocks: totalBlocks,
-					DoneBlocks: parts-1,
-					DoneSize: int64(cc.BlockSize)*(parts-1),
-				})
-			}
+		readSize += int64(nr)
+		if err == io.ErrUnexpectedEOF && readSize != fileSize {
+			// a short block that isn't the last one means the file shrank
+			return fmt.Errorf("file size changed during upload: %s", filePath)
+		}
+		if fileHash != nil {
+			fileHash.Write(buffer[:nr])
+			pieces = append(pieces, sha256Hex(buffer[:nr]))
+		}
 
-			ticket, err := cc.putDataBlock(putURL, buffer[:nr], uploadJob.Token)
-			if err != nil {
-				if cc.MaxRetry <= 0 {
-					return fmt.Errorf("cannot push block %d: %v", parts, err)
-				}
-
-				for i := 0; i < cc.MaxRetry; i++ {
-					if cc.transferProgressHook != nil {
-						cc.transferProgressHook(&FileTransfer{
-							Path: filePath,
-							Size: fileSize,
-							State: RetryBlock,
-							BlockSize: nr,
-							BlockNumber: parts,
-							Blocks: totalBlocks,
-							DoneBlocks: parts-1,
-							DoneSize: int64(cc.BlockSize)*(parts-1),
-							Retry: i+1,
-							RetriesLeft: cc.MaxRetry-i-1,
-							Error: err,
-						})
-					}
-
-					ticket, err = cc.putDataBlock(putURL, buffer[:nr], uploadJob.Token)
-					if err == nil {
-						break
-					}
-				}
-			}
-			if err != nil {
-				return fmt.Errorf("cannot push block %d: %v", parts, err)
-			}
-			if ticket == "" {
-				return fmt.Errorf("missing block %d ticket: %s", parts, filePath)
-			}
+		putURL := fmt.Sprintf(ossPushBlockURL, cc.OSSURL, uploadJob.EncodeID, uploadJob.ID, parts)
 
-			if cc.transferProgressHook != nil {
-				cc.transferProgressHook(&FileTransfer{
-					Path: filePath,
-					Size: fileSize,
-					State: DoneBlock,
-					BlockSize: nr,
-					BlockNumber: parts,
-					Blocks: totalBlocks,
-					DoneBlocks: parts,
-					DoneSize: int64(cc.BlockSize)*(parts-1)+int64(nr),
-				})
-			}
+		if cc.events.active() {
+			cc.emitTransfer(&FileTransfer{
+				Path: filePath,
+				Size: fileSize,
+				State: DoBlock,
+				BlockSize: nr,
+				BlockNumber: parts,
+				Blocks: totalBlocks,
+				DoneBlocks: parts-1,
+				DoneSize: int64(cc.BlockSize)*(parts-1),
+			})
+		}
 
-			hashmap[parts] = ticket
+		ticket, err := cc.pushBlock(putURL, buffer[:nr], uploadJob.Token, FileTransfer{
+			Path: filePath,
+			Size: fileSize,
+			BlockSize: nr,
+			BlockNumber: parts,
+			Blocks: totalBlocks,
+			DoneBlocks: parts-1,
+			DoneSize: int64(cc.BlockSize)*(parts-1),
+		}, &fr.Retries)
+		if errors.Is(err, ErrSkipFile) {
+			cc.skipFileTransfer(filePath, fileSize, totalBlocks, err)
+			return ErrSkipFile
 		}
+		if err != nil {
+			return fmt.Errorf("cannot push block %d: %w", parts, err)
+		}
+		if ticket == "" {
+			return fmt.Errorf("missing block %d ticket: %s", parts, filePath)
+		}
+
+		if cc.events.active() {
+			cc.emitTransfer(&FileTransfer{
+				Path: filePath,
+				Size: fileSize,
+				State: DoneBlock,
+				BlockSize: nr,
+				BlockNumber: parts,
+				Blocks: totalBlocks,
+				DoneBlocks: parts,
+				DoneSize: int64(cc.BlockSize)*(parts-1)+int64(nr),
+			})
+		}
+
+		hashmap[parts] = ticket
+	}
+	if readSize != fileSize {
+		return fmt.Errorf("file size changed during upload: %s", filePath)
 	}
-	_ = uploadFile.Close()
 
 	fileBlocks := []fileBlockSlek{}
 	okBlocks := int64(0)
@@ -333,8 +464,8 @@ func (cc *CowClient) uploadFileBlocksSerial(filePath string, session *uploadSess
 		})
 	}
 
-	if cc.transferProgressHook != nil {
-		cc.transferProgressHook(&FileTransfer{
+	if cc.events.active() {
+		cc.emitTransfer(&FileTransfer{
 			Path: filePath,
 			Size: fileSize,
 			State: ConfirmUpload,
@@ -344,13 +475,22 @@ func (cc *CowClient) uploadFileBlocksSerial(filePath string, session *uploadSess
 		})
 	}
 
-	err = cc.finishFileUpload(uploadJob, fi, &fileBlocks)
+	fr.Hash, err = cc.finishFileUpload(uploadJob, fr.Name, &fileBlocks)
 	if err != nil {
-		return fmt.Errorf("cannot finish upload: %v", err)
+		return fmt.Errorf("cannot finish upload: %w", err)
+	}
+	if fileHash != nil {
+		session.manifest.add(ManifestFile{
+			Name: fr.Name,
+			Size: fileSize,
+			SHA256: hex.EncodeToString(fileHash.Sum(nil)),
+			PieceLength: int64(cc.BlockSize),
+			Pieces: pieces,
+		})
 	}
 
-	if cc.transferProgressHook != nil {
-		cc.transferProgressHook(&FileTransfer{
+	if cc.events.active() {
+		cc.emitTransfer(&FileTransfer{
 			Path: filePath,
 			Size: fileSize,
 			State: FinishTransfer,
@@ -362,18 +502,29 @@ func (cc *CowClient) uploadFileBlocksSerial(filePath string, session *uploadSess
 	return nil
 }
 
-// uploadFileBlocksSerial uploads a file many blocks at a time.
-func (cc *CowClient) uploadFileBlocksParallel(filePath string, session *uploadSessionResponse) error {
+// hashResult is the whole file hash computed next to a parallel upload.
+type hashResult struct {
+	sha256 string
+	err    error
+}
+
+// uploadFileBlocksParallel uploads a file many blocks at a time. Blocks are 
+// not read here; each worker reads its own section of the file into a buffer 
+// borrowed from pool. What is learned about the file is recorded in fr.
+func (cc *CowClient) uploadFileBlocksParallel(fr *FileResult, session *uploadSessionResponse, pool *blockPool) error {
+	filePath := fr.Path
 	fi, err := os.Stat(filePath)
 	if err != nil {
-		return fmt.Errorf("cannot read file %s: %v", filePath, err)
+		return fmt.Errorf("cannot read file %s: %w", filePath, err)
 	}
 	// estimate the total number of blocks to upload
 	fileSize := fi.Size()
 	totalBlocks := blocksInFile(fileSize, cc.BlockSize)
+	fr.Size = fileSize
+	fr.Blocks = totalBlocks
 
-	if cc.transferProgressHook != nil {
-		cc.transferProgressHook(&FileTransfer{
+	if cc.events.active() {
+		cc.emitTransfer(&FileTransfer{
 			Path: filePath,
 			State: InitTransfer,
 			Size: fileSize,
@@ -381,71 +532,120 @@ func (cc *CowClient) uploadFileBlocksParallel(filePath string, session *uploadSe
 		})
 	}
 
-	uploadJob, err := cc.newFileUpload(fi, session)
+	uploadJob, err := cc.newFileUpload(fr.Name, fileSize, session)
 	if err != nil {
 		return err
 	}
+	fr.FileGUID = uploadJob.FileGUID
 
 	uploadFile, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("cannot open file %s: %v", filePath, err)
+		return fmt.Errorf("cannot open file %s: %w", filePath, err)
 	}
 
 	wg := new(sync.WaitGroup)
 	hashmap := int64map{}
+	// blocks are read out of order, so the file is hashed on the side while 
+	// the blocks are pushed
+	var pieces []string
+	var fileHash chan hashResult
+	if session.manifest != nil {
+		pieces = make([]string, totalBlocks)
+		fileHash = make(chan hashResult, 1)
+		go func() {
+			mf, err := HashFile(filePath, cc.BlockSize)
+			fileHash <- hashResult{mf.SHA256, err}
+		}()
+	}
+
+	// more workers than buffers would only wait on the pool
+	workers := cc.MaxPushBlocks
+	if workers > pool.Slots() {
+		workers = pool.Slots()
+	}
 
 	uploadChan := make(chan *fileBlockUpload)
-	for i := 0; i < cc.MaxPushBlocks; i++ {
-		go cc.uploadFileBlock(&uploadChan, wg, uploadJob, &hashmap)
+	for i := 0; i < workers; i++ {
+		go cc.uploadFileBlock(&uploadChan, wg, uploadJob, &hashmap, pool)
 	}
 
-	parts := int64(0)
-	for {
-		buffer := make([]byte, cc.BlockSize)
-		nr, err := uploadFile.Read(buffer)
-		if nr <= 0 || err != nil {
-			// #todo handle err
-			break
+	sendBlock := func(part int64) {
+		offset := (part-1)*int64(cc.BlockSize)
+		size := int64(cc.BlockSize)
+		if offset+size > fileSize {
+			size = fileSize-offset
 		}
-		parts++
-		if nr > 0 {
-			wg.Add(1)
-			uploadChan <- &fileBlockUpload{
-				content: buffer[:nr],
-				count: parts,
-				filePath: filePath,
-				fileSize: fileSize,
-				totalBlocks: totalBlocks,
-			}
+
+		wg.Add(1)
+		uploadChan <- &fileBlockUpload{
+			section: io.NewSectionReader(uploadFile, offset, size),
+			count: part,
+			filePath: filePath,
+			fileSize: fileSize,
+			totalBlocks: totalBlocks,
+			pieces: pieces,
+			retries: &fr.Retries,
 		}
 	}
 
+	parts := totalBlocks
+	for i := int64(1); i <= parts; i++ {
+		sendBlock(i)
+	}
 	wg.Wait()
+
+	// second pass: push failed blocks again with a fresh retry budget, so 
+	// that a brief outage doesn't waste all the blocks already pushed. This 
+	// isn't needed when the push block error handler has made a decision.
+	if failed := hashmap.Failed(); len(failed) > 0 && hashmap.Aborted() == nil {
+		for _, v := range failed {
+			sendBlock(v)
+		}
+		wg.Wait()
+	}
+
 	close(uploadChan)
 	_ = uploadFile.Close()
 
+	if err := hashmap.Aborted(); err != nil {
+		if errors.Is(err, ErrSkipFile) {
+			cc.skipFileTransfer(filePath, fileSize, totalBlocks, err)
+			return ErrSkipFile
+		}
+		return err
+	}
+
 	fileBlocks := []fileBlockSlek{}
 	okBlocks := int64(0)
+	blockErrs := BlockErrors{}
 	for i := int64(1); i <= parts; i++ {
 		ticket, err, ok := hashmap.Load(i)
 		if !ok {
-			return fmt.Errorf("missing block %d: %s", i, filePath)
+			err = fmt.Errorf("block not pushed")
+		} else if err == nil && ticket == "" {
+			err = fmt.Errorf("missing block ticket")
 		}
 		if err != nil {
-			return fmt.Errorf("error pushing block %d: %v", i, err)
-		}
-		if ticket == "" {
-			return fmt.Errorf("missing block %d ticket: %s", i, filePath)
+			blockErrs = append(blockErrs, &BlockError{
+				Path: filePath,
+				Part: i,
+				Err: err,
+			})
+			continue
 		}
 
+		okBlocks++
 		fileBlocks = append(fileBlocks, fileBlockSlek{
 			ETag: ticket,
 			Part: i,
 		})
 	}
+	if len(blockErrs) > 0 {
+		return blockErrs
+	}
 
-	if cc.transferProgressHook != nil {
-		cc.transferProgressHook(&FileTransfer{
+	if cc.events.active() {
+		cc.emitTransfer(&FileTransfer{
 			Path: filePath,
 			Size: fileSize,
 			State: ConfirmUpload,
@@ -455,13 +655,31 @@ func (cc *CowClient) uploadFileBlocksParallel(filePath string, session *uploadSe
 		})
 	}
 
-	err = cc.finishFileUpload(uploadJob, fi, &fileBlocks)
+	// a file that cannot be hashed fails before it is merged, so that the 
+	// manifest never lacks a hash
+	var hashed hashResult
+	if fileHash != nil {
+		if hashed = <-fileHash; hashed.err != nil {
+			return fmt.Errorf("cannot hash file %s: %w", filePath, hashed.err)
+		}
+	}
+
+	fr.Hash, err = cc.finishFileUpload(uploadJob, fr.Name, &fileBlocks)
 	if err != nil {
-		return fmt.Errorf("cannot finish upload: %v", err)
+		return fmt.Errorf("cannot finish upload: %w", err)
+	}
+	if pieces != nil {
+		session.manifest.add(ManifestFile{
+			Name: fr.Name,
+			Size: fileSize,
+			SHA256: hashed.sha256,
+			PieceLength: int64(cc.BlockSize),
+			Pieces: pieces,
+		})
 	}
 
-	if cc.transferProgressHook != nil {
-		cc.transferProgressHook(&FileTransfer{
+	if cc.events.active() {
+		cc.emitTransfer(&FileTransfer{
 			Path: filePath,
 			Size: fileSize,
 			State: FinishTransfer,
@@ -473,88 +691,164 @@ func (cc *CowClient) uploadFileBlocksParallel(filePath string, session *uploadSe
 	return nil
 }
 
-// uploadFileBlock should run as a goroutine. It calls putDataBlock to upload 
-// file parts (blocks) to the OSS block upload endpoint.
-func (cc *CowClient) uploadFileBlock(ch *chan *fileBlockUpload, wg *sync.WaitGroup, job *ossInitUploadResponse, hashmap *int64map) {
+// pushBlock calls putDataBlock, retrying up to MaxRetry times. RetryBlock 
+// progress is reported using ft as a template. When retries run out, the push 
+// block error handler decides whether to try again, skip the file or abort. 
+// Every retry is counted in retries, if not nil.
+func (cc *CowClient) pushBlock(putURL string, buffer []byte, token string, ft FileTransfer, retries *int64) (string, error) {
+	countRetry := func() {
+		if retries != nil {
+			atomic.AddInt64(retries, 1)
+		}
+	}
+
+	ticket, err := cc.putDataBlock(putURL, buffer, token)
+	for err != nil {
+		for i := 0; i < cc.MaxRetry && err != nil; i++ {
+			countRetry()
+			cc.logger().Warn("retry block", "path", ft.Path, "block", ft.BlockNumber, "retry", i+1, "error", err)
+			if cc.events.active() {
+				retry := ft
+				retry.State = RetryBlock
+				retry.Retry = i+1
+				retry.RetriesLeft = cc.MaxRetry-i-1
+				retry.Error = err
+				cc.emitTransfer(&retry)
+			}
+
+			ticket, err = cc.putDataBlock(putURL, buffer, token)
+		}
+		if err == nil || cc.pushBlockErrorHandler == nil {
+			break
+		}
+
+		failed := ft
+		failed.State = FailBlock
+		failed.Retry = cc.MaxRetry
+		failed.Error = err
+		decision := cc.pushBlockErrorHandler(&failed)
+
+		var wait *retryBlockError
+		if errors.As(decision, &wait) {
+			time.Sleep(wait.wait)
+		} else if decision != nil && !errors.Is(decision, ErrRetryBlock) {
+			return "", decision
+		}
+
+		countRetry()
+		ticket, err = cc.putDataBlock(putURL, buffer, token)
+	}
+	return ticket, err
+}
+
+// skipFileTransfer reports that the rest of a file is skipped.
+func (cc *CowClient) skipFileTransfer(filePath string, fileSize int64, totalBlocks int64, reason error) {
+	if cc.events.active() {
+		cc.emitTransfer(&FileTransfer{
+			Path: filePath,
+			Size: fileSize,
+			State: SkipTransfer,
+			Blocks: totalBlocks,
+			Error: reason,
+		})
+	}
+}
+
+// uploadFileBlock should run as a goroutine. It reads each block it receives 
+// into a buffer from pool, then calls putDataBlock to upload file parts 
+// (blocks) to the OSS block upload endpoint.
+func (cc *CowClient) uploadFileBlock(ch *chan *fileBlockUpload, wg *sync.WaitGroup, job *ossInitUploadResponse, hashmap *int64map, pool *blockPool) {
 	for item := range *ch {
-		putURL := fmt.Sprintf(ossPushBlockURL, cc.OSSURL, job.EncodeID, job.ID, item.count)
+		buffer := pool.Get()
+		cc.uploadFileSection(item, buffer, job, hashmap)
+		pool.Put(buffer)
+		wg.Done()
+	}
+}
+
+// uploadFileSection reads the block described by item into buffer and pushes 
+// it. The outcome is recorded in hashmap.
+func (cc *CowClient) uploadFileSection(item *fileBlockUpload, buffer []byte, job *ossInitUploadResponse, hashmap *int64map) {
+	if err := hashmap.Aborted(); err != nil {
+		hashmap.StoreError(item.count, err)
+		return
+	}
+
+	nr, err := item.section.ReadAt(buffer[:item.section.Size()], 0)
+	if err != nil {
+		hashmap.StoreError(item.count, fmt.Errorf("cannot read block %d: %w", item.count, err))
+		return
+	}
+	content := buffer[:nr]
+	if item.pieces != nil {
+		item.pieces[item.count-1] = sha256Hex(content)
+	}
+
+	putURL := fmt.Sprintf(ossPushBlockURL, cc.OSSURL, job.EncodeID, job.ID, item.count)
 
-		doneBlocks := int64(0)
-		doneSize := int64(0)
+	doneBlocks := int64(0)
+	doneSize := int64(0)
 
-		if cc.transferProgressHook != nil {
-			doneBlocks, doneSize = hashmap.Size()
+	if cc.events.active() {
+		doneBlocks, doneSize = hashmap.Size()
+
+		cc.emitTransfer(&FileTransfer{
+			Path: item.filePath,
+			Size: item.fileSize,
+			State: DoBlock,
+			BlockSize: len(content),
+			BlockNumber: item.count,
+			Blocks: item.totalBlocks,
+			DoneBlocks: doneBlocks,
+			DoneSize: doneSize,
+		})
+	}
 
-			cc.transferProgressHook(&FileTransfer{
+	ticket, err := cc.pushBlock(putURL, content, job.Token, FileTransfer{
+		Path: item.filePath,
+		Size: item.fileSize,
+		BlockSize: len(content),
+		BlockNumber: item.count,
+		Blocks: item.totalBlocks,
+		DoneBlocks: doneBlocks,
+		DoneSize: doneSize,
+	}, item.retries)
+	if err != nil {
+		if cc.pushBlockErrorHandler != nil {
+			// the handler chose to skip the file or abort the session
+			hashmap.Abort(fmt.Errorf("cannot push block %d: %w", item.count, err))
+		}
+		hashmap.StoreError(item.count, err)
+	} else {
+		// other workers may have pushed blocks since doneBlocks was read, so 
+		// the count is taken again as the block is stored
+		doneBlocks, doneSize = hashmap.Store(item.count, ticket, len(content))
+		if cc.events.active() {
+			cc.emitTransfer(&FileTransfer{
 				Path: item.filePath,
 				Size: item.fileSize,
-				State: DoBlock,
-				BlockSize: len(item.content),
+				State: DoneBlock,
+				BlockSize: len(content),
 				BlockNumber: item.count,
 				Blocks: item.totalBlocks,
 				DoneBlocks: doneBlocks,
 				DoneSize: doneSize,
 			})
 		}
-
-		ticket, err := cc.putDataBlock(putURL, item.content, job.Token)
-		if err != nil && cc.MaxRetry > 0 {
-			for i := 0; i < cc.MaxRetry; i++ {
-				if cc.transferProgressHook != nil {
-					cc.transferProgressHook(&FileTransfer{
-						Path: item.filePath,
-						Size: item.fileSize,
-						State: RetryBlock,
-						BlockSize: len(item.content),
-						BlockNumber: item.count,
-						Blocks: item.totalBlocks,
-						DoneBlocks: doneBlocks,
-						DoneSize: doneSize,
-						Retry: i+1,
-						RetriesLeft: cc.MaxRetry-i-1,
-						Error: err,
-					})
-				}
-
-				ticket, err = cc.putDataBlock(putURL, item.content, job.Token)
-				if err == nil {
-					break
-				}
-			}
-		}
-		if err != nil {
-			hashmap.StoreError(item.count, err)
-		} else {
-			if cc.transferProgressHook != nil {
-				cc.transferProgressHook(&FileTransfer{
-					Path: item.filePath,
-					Size: item.fileSize,
-					State: DoneBlock,
-					BlockSize: len(item.content),
-					BlockNumber: item.count,
-					Blocks: item.totalBlocks,
-					DoneBlocks: doneBlocks+1,
-					DoneSize: doneSize+int64(len(item.content)),
-				})
-			}
-
-			hashmap.Store(item.count, ticket, len(item.content))
-		}
-		wg.Done()
 	}
 }
 
 // newFileUpload calls the file management API to create a file upload 
-// operation. It then calls the OSS blocks upload init endpoint to create a 
-// blocks upload job.
-func (cc *CowClient) newFileUpload(fi os.FileInfo, session *uploadSessionResponse) (*ossInitUploadResponse, error) {
+// operation named name. It then calls the OSS blocks upload init endpoint to 
+// create a blocks upload job.
+func (cc *CowClient) newFileUpload(name string, size int64, session *uploadSessionResponse) (*ossInitUploadResponse, error) {
 	// first signal to uploadFileURL API that we want to upload a file
 	data := map[string]string{
 		"fileId":        "",
 		"type":          "",
-		"fileName":      fi.Name(),
-		"originalName":  fi.Name(),
-		"fileSize":      strconv.FormatInt(fi.Size(), 10),
+		"fileName":      name,
+		"originalName":  name,
+		"fileSize":      strconv.FormatInt(size, 10),
 		"transferGuid":  session.TransferGUID,
 		"storagePrefix": session.Prefix,
 	}
@@ -582,9 +876,9 @@ func (cc *CowClient) newFileUpload(fi os.FileInfo, session *uploadSessionRespons
 		return nil, err
 	}
 
-	w := urlEncodeBase64(fmt.Sprintf("%s/%s/%s", session.Prefix, session.TransferGUID, fi.Name()))
+	w := urlEncodeBase64(fmt.Sprintf("%s/%s/%s", session.Prefix, session.TransferGUID, name))
 	initURL := fmt.Sprintf(ossInitPushURL, cc.OSSURL, w)
-	responseBytes, err = cc.newFileUploadRequest(initURL, bytes.NewReader(postBody), session.UploadToken, "POST")
+	responseBytes, err = cc.newFileUploadRequest(initURL, bytes.NewReader(postBody), "application/json", session.UploadToken, "POST")
 	if err != nil {
 		return nil, err
 	}
@@ -602,27 +896,28 @@ func (cc *CowClient) newFileUpload(fi os.FileInfo, session *uploadSessionRespons
 }
 
 // finishFileUpload calls the OSS merge blocks API, followed by the file 
-// management API to signal that the file has been uploaded.
-func (cc *CowClient) finishFileUpload(job *ossInitUploadResponse, fi os.FileInfo, sleks *[]fileBlockSlek) error {
+// management API to signal that the file has been uploaded. It returns the 
+// hash of the merged file.
+func (cc *CowClient) finishFileUpload(job *ossInitUploadResponse, name string, sleks *[]fileBlockSlek) (string, error) {
 	mergeBlocksURL := fmt.Sprintf(ossFinishPushURL, cc.OSSURL, job.EncodeID, job.ID)
 	postData := ossMergeBlocksRequest{
 		Parts: *sleks,
-		FName: fi.Name(),
+		FName: name,
 	}
 	postBody, err := json.Marshal(postData)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	reader := bytes.NewReader(postBody)
-	resp, err := cc.newFileUploadRequest(mergeBlocksURL, reader, job.Token, "POST")
+	resp, err := cc.newFileUploadRequest(mergeBlocksURL, reader, "application/json", job.Token, "POST")
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	var mergeResponse *ossMergeBlocksResponse
 	if err = json.Unmarshal(resp, &mergeResponse); err != nil {
-		return err
+		return "", err
 	}
 
 	// now signal to finishUploadFileURL that the file's done
@@ -633,12 +928,12 @@ func (cc *CowClient) finishFileUpload(job *ossInitUploadResponse, fi os.FileInfo
 	}
 	bodyBytes, err := cc.newMultipartFormRequest(fmt.Sprintf(finishUploadFileURL, cc.APIURL), data)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if string(bodyBytes) != "true" {
-		return ErrInvalidResponse
+		return "", ErrInvalidResponse
 	}
-	return nil
+	return mergeResponse.Hash, nil
 }
 
 // addHeaders is a helper method to add headers to a HTTP request. These 
@@ -649,34 +944,53 @@ func (cc *CowClient) addHeaders(req *http.Request) *http.Request {
 	req.Header.Set("Referer", refererURL)
 	req.Header.Set("User-Agent", cc.UserAgent)
 	req.Header.Set("Origin", refererURL)
-	req.Header.Set("Cookie", fmt.Sprintf(defaultCookieFormat, req.Header.Get("Cookie"), time.Now().UnixNano()))
+	req.Header.Set("Cookie", fmt.Sprintf(defaultCookieFormat, cc.tokenCookies(), time.Now().UnixNano()))
 	return req
 }
 
-// newFileUploadRequest is a general wrapper for upload related API calls.
-func (cc *CowClient) newFileUploadRequest(url string, postBody io.Reader, uploadToken string, httpMethod string) ([]byte, error) {
+// tokenCookies returns Token ready to be prefixed to other cookies. With a 
+// cookie jar, Token goes into the jar instead and nothing is returned, so 
+// that a cookie is never sent twice.
+func (cc *CowClient) tokenCookies() string {
+	token := strings.TrimSpace(cc.Token)
+	if token != "" && cc.Cookies != nil {
+		cc.Cookies.addToken(cc.APIURL, token)
+		return ""
+	}
+	if token != "" && !strings.HasSuffix(token, ";") {
+		token += ";"
+	}
+	return token
+}
+
+// httpClient returns a HTTP client that uses Transport and the cookie jar.
+func (cc *CowClient) httpClient() *http.Client {
+	client := &http.Client{Timeout: cc.Timeout, Transport: cc.Transport}
+	if cc.Cookies != nil {
+		client.Jar = cc.Cookies
+	}
+	return client
+}
+
+// newFileUploadRequest is a general wrapper for upload related API calls. 
+// postBody is sent as contentType.
+func (cc *CowClient) newFileUploadRequest(url string, postBody io.Reader, contentType string, uploadToken string, httpMethod string) ([]byte, error) {
 	refererURL := cc.APIURL
 
-	client := http.Client{Timeout: cc.Timeout}
+	client := cc.httpClient()
 	req, err := http.NewRequest(httpMethod, url, postBody)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("referer", refererURL)
-	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Authorization", "UpToken "+uploadToken)
 
-	response, err := client.Do(req)
+	_, bodyBytes, err := cc.doRequest(client, req, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	bodyBytes, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
-	}	
-	_ = response.Body.Close()
-
 	return bodyBytes, nil
 }
 
@@ -685,7 +999,7 @@ func (cc *CowClient) newFileUploadRequest(url string, postBody io.Reader, upload
 func (cc *CowClient) newMultipartFormRequest(url string, params map[string]string) ([]byte, error) {
 	refererURL := cc.APIURL
 
-	client := http.Client{Timeout: cc.Timeout}
+	client := cc.httpClient()
 	buffer := &bytes.Buffer{}
 	writer := multipart.NewWriter(buffer)
 	for key, val := range params {
@@ -700,26 +1014,12 @@ func (cc *CowClient) newMultipartFormRequest(url string, params map[string]strin
 
 	req.Header.Set("content-type", fmt.Sprintf("multipart/form-data;boundary=%s", writer.Boundary()))
 	req.Header.Set("referer", refererURL)
-	req.Header.Set("cookie", cc.Token)
 
-	response, err := client.Do(cc.addHeaders(req))
+	_, bodyBytes, err := cc.doRequest(client, cc.addHeaders(req), params)
 	if err != nil {
 		return nil, err
 	}
 
-	bodyBytes, err := io.ReadAll(response.Body)
-	if err != nil {
-		return nil, err
-	}
-	_ = response.Body.Close()
-
-	if s := response.Header.Values("Set-Cookie"); len(s) != 0 && cc.Token == "" {
-		for _, v := range s {
-			ck := strings.Split(v, ";")
-			cc.Token += ck[0] + ";"
-		}
-	}
-
 	return bodyBytes, nil
 }
 
@@ -728,7 +1028,7 @@ func (cc *CowClient) newMultipartFormRequest(url string, params map[string]strin
 func (cc *CowClient) putDataBlock(url string, buffer []byte, token string) (string, error) {
 	data := new(bytes.Buffer)
 	data.Write(buffer)
-	body, err := cc.newFileUploadRequest(url, data, token, "PUT")
+	body, err := cc.newFileUploadRequest(url, data, "application/octet-stream", token, "PUT")
 	if err != nil {
 		return "", err
 	}
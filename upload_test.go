@@ -0,0 +1,320 @@
+package cowtransfer
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckBlockSize(t *testing.T) {
+	cases := []struct {
+		blockSize int
+		ok        bool
+	}{
+		{262144, false},
+		{minBlockSize - 1, false},
+		{minBlockSize, true},
+		{defaultBlockSize, true},
+		{maxBlockSize, true},
+		{maxBlockSize + 1, false},
+	}
+
+	for _, c := range cases {
+		cc := NewClient()
+		cc.BlockSize = c.blockSize
+		err := cc.checkBlockSize([]string{"./testdata/dummy.txt"})
+		if c.ok && err != nil {
+			t.Errorf("block size %d: unexpected error: %v", c.blockSize, err)
+		}
+		if !c.ok && !errors.Is(err, ErrBlockSize) {
+			t.Errorf("block size %d: expected ErrBlockSize, got %v", c.blockSize, err)
+		}
+	}
+}
+
+func TestPushBlockErrorHandler(t *testing.T) {
+	failures := 3
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `{"etag":"abc","md5":""}`)
+	}))
+	defer ts.Close()
+
+	cc := NewClient()
+	cc.MaxRetry = 1
+	cc.VerifyHash = false
+
+	calls := 0
+	cc.OnPushBlockError(func(ft *FileTransfer) error {
+		calls++
+		if ft.State != FailBlock || ft.Error == nil {
+			t.Errorf("unexpected handler input: %v %v", ft.State, ft.Error)
+		}
+		return RetryBlockAfter(time.Millisecond)
+	})
+
+	ticket, err := cc.pushBlock(ts.URL, []byte("data"), "token", FileTransfer{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ticket != "abc" {
+		t.Errorf("expected ticket abc, got %q", ticket)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 handler call, got %d", calls)
+	}
+
+	failures = 10
+	cc.OnPushBlockError(func(ft *FileTransfer) error {
+		return ErrSkipFile
+	})
+	if _, err := cc.pushBlock(ts.URL, []byte("data"), "token", FileTransfer{}, nil); err != ErrSkipFile {
+		t.Errorf("expected ErrSkipFile, got %v", err)
+	}
+}
+
+// newFakeUploadServer serves the Cowtransfer and Qiniu APIs used by Upload.
+// Use its URL for both APIURL and OSSURL.
+func newFakeUploadServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/transfer/preparesend":
+			fmt.Fprint(w, `{"uptoken":"t","transferguid":"g","uniqueurl":"https://cowtransfer.com/s/abab0000123456","prefix":"p"}`)
+		case r.URL.Path == "/transfer/beforeupload":
+			fmt.Fprint(w, `{"fileGuid":"f"}`)
+		case r.URL.Path == "/transfer/uploaded":
+			fmt.Fprint(w, "true")
+		case r.URL.Path == "/transfer/complete":
+			fmt.Fprint(w, `{"tempDownloadCode":"123456","complete":true}`)
+		case strings.HasSuffix(r.URL.Path, "/uploads"):
+			fmt.Fprint(w, `{"uploadId":"id"}`)
+		case r.Method == "PUT":
+			fmt.Fprint(w, `{"etag":"e","md5":""}`)
+		case strings.HasSuffix(r.URL.Path, "/uploads/id"):
+			fmt.Fprint(w, `{"hash":"qhash","key":"k"}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestUploadWithResult(t *testing.T) {
+	fake := newFakeUploadServer(t)
+	defer fake.Close()
+	// fail the first block push, so that it is retried
+	failed := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" && !failed {
+			failed = true
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		r.URL.Scheme, r.URL.Host = "http", strings.TrimPrefix(fake.URL, "http://")
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			t.Errorf("cannot forward request: %v", err)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	if err := os.WriteFile(path, make([]byte, minBlockSize+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cc := NewClient()
+	cc.APIURL = ts.URL
+	cc.OSSURL = ts.URL
+	cc.VerifyHash = false
+	cc.BlockSize = minBlockSize
+	result, err := cc.UploadWithResult(nil, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.TransferGUID != "g" || result.TempCode != "123456" || result.UniqueURL != "https://cowtransfer.com/s/abab0000123456" {
+		t.Errorf("unexpected session result: %+v", result)
+	}
+	if len(result.Files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(result.Files))
+	}
+	fr := result.Files[0]
+	if fr.Status != FileUploaded || fr.Name != "data.bin" || fr.Size != minBlockSize+1 || fr.Blocks != 2 {
+		t.Errorf("unexpected file result: %+v", fr)
+	}
+	if fr.Retries != 1 || fr.Hash != "qhash" || fr.FileGUID != "f" {
+		t.Errorf("expect 1 retry, hash qhash and guid f, got %d, %s, %s", fr.Retries, fr.Hash, fr.FileGUID)
+	}
+	if fr.Duration <= 0 || fr.Throughput() <= 0 {
+		t.Errorf("expect duration and throughput, got %v", fr.Duration)
+	}
+
+	// a missing file fails before the session opens
+	if result, err := cc.UploadWithResult(nil, path, filepath.Join(dir, "missing")); err == nil || result != nil {
+		t.Errorf("expect error and no result, got %v, %v", result, err)
+	}
+}
+
+// failBlocks forwards requests to fake, and answers block pushes itself with
+// the right checksum, unless fail does. It returns the number of pushes of
+// every block.
+func failBlocks(fake *httptest.Server, fail func(w http.ResponseWriter, part string, count int64) bool) (*httptest.Server, map[string]*int64) {
+	counts := map[string]*int64{"1": new(int64), "2": new(int64), "3": new(int64)}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			part := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+			body, _ := io.ReadAll(r.Body)
+			if !fail(w, part, atomic.AddInt64(counts[part], 1)) {
+				fmt.Fprintf(w, `{"etag":"e","md5":"%x"}`, md5.Sum(body))
+			}
+			return
+		}
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	return ts, counts
+}
+
+func newParallelTestClient(t *testing.T, url string) (*CowClient, string) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, make([]byte, 3*minBlockSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cc := NewClient()
+	cc.APIURL = url
+	cc.OSSURL = url
+	cc.VerifyHash = false
+	cc.BlockSize = minBlockSize
+	cc.MaxPushBlocks = 3
+	cc.MaxRetry = 0
+	return cc, path
+}
+
+func TestUploadParallelRecovery(t *testing.T) {
+	fake := newFakeUploadServer(t)
+	defer fake.Close()
+	// block 2 fails the first time only, and has no retries left
+	ts, counts := failBlocks(fake, func(w http.ResponseWriter, part string, count int64) bool {
+		if part == "2" && count == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	})
+	defer ts.Close()
+
+	cc, path := newParallelTestClient(t, ts.URL)
+	result, err := cc.UploadWithResult(nil, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fr := result.Files[0]; fr.Status != FileUploaded || fr.Retries != 0 {
+		t.Errorf("expected the file to be uploaded without retries, got %+v", fr)
+	}
+	if atomic.LoadInt64(counts["1"]) != 1 || atomic.LoadInt64(counts["2"]) != 2 || atomic.LoadInt64(counts["3"]) != 1 {
+		t.Errorf("expected only block 2 to be pushed again, got %d, %d, %d", atomic.LoadInt64(counts["1"]), atomic.LoadInt64(counts["2"]), atomic.LoadInt64(counts["3"]))
+	}
+}
+
+func TestUploadParallelBlockErrors(t *testing.T) {
+	fake := newFakeUploadServer(t)
+	defer fake.Close()
+	// block 2 always has a bad checksum, and block 3 always loses the
+	// connection
+	ts, counts := failBlocks(fake, func(w http.ResponseWriter, part string, count int64) bool {
+		switch part {
+		case "2":
+			fmt.Fprint(w, `{"etag":"e","md5":"bad"}`)
+			return true
+		case "3":
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return true
+		}
+		return false
+	})
+	defer ts.Close()
+
+	cc, path := newParallelTestClient(t, ts.URL)
+	cc.VerifyHash = true
+	result, err := cc.UploadWithResult(nil, path)
+	if result == nil || result.Files[0].Status != FileFailed {
+		t.Fatalf("expected a failed file, got %+v", result)
+	}
+	var blockErrs BlockErrors
+	if !errors.As(err, &blockErrs) {
+		t.Fatalf("expected BlockErrors, got %v", err)
+	}
+	if len(blockErrs) != 2 || blockErrs[0].Part != 2 || blockErrs[1].Part != 3 || blockErrs[0].Path != path {
+		t.Errorf("expected blocks 2 and 3 of %s to fail, got %v", path, blockErrs)
+	}
+	if !errors.Is(err, ErrBlockChecksum) {
+		t.Errorf("expected errors.Is to find ErrBlockChecksum in %v", err)
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) {
+		t.Errorf("expected errors.As to find a net.Error in %v", err)
+	}
+	// both blocks are pushed again in the recovery pass
+	if atomic.LoadInt64(counts["2"]) != 2 || atomic.LoadInt64(counts["3"]) < 2 {
+		t.Errorf("expected blocks 2 and 3 to be pushed twice, got %d and %d", atomic.LoadInt64(counts["2"]), atomic.LoadInt64(counts["3"]))
+	}
+}
+
+func TestUploadParallelProgress(t *testing.T) {
+	fake := newFakeUploadServer(t)
+	defer fake.Close()
+	cc, path := newParallelTestClient(t, fake.URL)
+
+	mutex := sync.Mutex{}
+	counts := map[int64]int{}
+	last := FileTransfer{}
+	cc.Subscribe(func(ev Event) {
+		be, ok := ev.(BlockEvent)
+		if !ok || be.State != DoneBlock {
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		counts[be.DoneBlocks]++
+		if be.DoneBlocks > last.DoneBlocks {
+			last = be.FileTransfer
+		}
+	})
+	if _, err := cc.UploadWithResult(nil, path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// every pushed block is counted exactly once, whatever the order
+	for i := int64(1); i <= 3; i++ {
+		if counts[i] != 1 {
+			t.Errorf("expected 1 event with %d done blocks, got %d (%v)", i, counts[i], counts)
+		}
+	}
+	if last.DoneBlocks != last.Blocks || last.DoneSize != 3*minBlockSize {
+		t.Errorf("expected all 3 blocks done, got %d of %d, %d bytes", last.DoneBlocks, last.Blocks, last.DoneSize)
+	}
+}